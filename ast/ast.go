@@ -10,6 +10,10 @@ import (
 type Node interface {
 	//返回与其关联的词法单元的字面量
 	TokenLiteral() string
+	//节点在源代码中的位置
+	Pos() token.Position
+	//节点最后一个字符之后的位置
+	End() token.Position
 	//
 	String() string
 }
@@ -25,6 +29,28 @@ type Expression interface {
 	expressionNode()
 }
 
+// Range 节点覆盖的源码范围。
+// 中缀、赋值、调用、下标表达式的 Pos 是运算符的位置,范围要从最左边的子节点开始
+func Range(node Node) token.Range {
+	return token.Range{Start: start(node), End: node.End()}
+}
+
+func start(node Node) token.Position {
+	switch node := node.(type) {
+	case *InfixExpression:
+		return start(node.Left)
+	case *AssignExpression:
+		return start(node.Name)
+	case *IndexAssignExpression:
+		return start(node.Left)
+	case *CallExpression:
+		return start(node.Function)
+	case *IndexExpression:
+		return start(node.Left)
+	}
+	return node.Pos()
+}
+
 type Program struct {
 	Statements []Statement
 }
@@ -38,6 +64,22 @@ func (p *Program) TokenLiteral() string {
 	}
 }
 
+// Pos ...
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+
+// End ...
+func (p *Program) End() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[len(p.Statements)-1].End()
+	}
+	return token.Position{}
+}
+
 // String ...
 func (p Program) String() string {
 	var out bytes.Buffer
@@ -62,6 +104,19 @@ func (ls *LetStatement) TokenLiteral() string {
 	return ls.Token.Literal
 }
 
+// Pos ...
+func (ls *LetStatement) Pos() token.Position {
+	return ls.Token.Pos
+}
+
+// End ...
+func (ls *LetStatement) End() token.Position {
+	if ls.Value != nil {
+		return ls.Value.End()
+	}
+	return ls.Name.End()
+}
+
 // String ...
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
@@ -84,6 +139,8 @@ type Identifier struct {
 
 func (i *Identifier) expressionNode()      {}
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
+func (i *Identifier) Pos() token.Position  { return i.Token.Pos }
+func (i *Identifier) End() token.Position  { return i.Token.Pos.Advance(i.Token.Literal) }
 func (i *Identifier) String() string {
 	return i.Value
 }
@@ -101,6 +158,19 @@ func (rs *ReturnStatement) TokenLiteral() string {
 	return rs.Token.Literal
 }
 
+// Pos ...
+func (rs *ReturnStatement) Pos() token.Position {
+	return rs.Token.Pos
+}
+
+// End ...
+func (rs *ReturnStatement) End() token.Position {
+	if rs.ReturnValue != nil {
+		return rs.ReturnValue.End()
+	}
+	return rs.Token.Pos.Advance(rs.Token.Literal)
+}
+
 // String ...
 func (rs *ReturnStatement) String() string {
 	var out bytes.Buffer
@@ -123,6 +193,13 @@ func (es *ExpressionStatement) StatementNode() {
 
 // TokenLiteral ...
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExpressionStatement) Pos() token.Position  { return es.Token.Pos }
+func (es *ExpressionStatement) End() token.Position {
+	if es.Expression != nil {
+		return es.Expression.End()
+	}
+	return es.Token.Pos.Advance(es.Token.Literal)
+}
 
 // String ...
 func (es *ExpressionStatement) String() string {
@@ -144,10 +221,31 @@ func (il *IntegerLiteral) TokenLiteral() string {
 	return il.Token.Literal
 }
 
+// Pos ...
+func (il *IntegerLiteral) Pos() token.Position {
+	return il.Token.Pos
+}
+
+// End ...
+func (il *IntegerLiteral) End() token.Position {
+	return il.Token.Pos.Advance(il.Token.Literal)
+}
+
 func (il *IntegerLiteral) String() string {
 	return il.Token.Literal
 }
 
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (fl *FloatLiteral) expressionNode()      {}
+func (fl *FloatLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FloatLiteral) Pos() token.Position  { return fl.Token.Pos }
+func (fl *FloatLiteral) End() token.Position  { return fl.Token.Pos.Advance(fl.Token.Literal) }
+func (fl *FloatLiteral) String() string       { return fl.Token.Literal }
+
 type PrefixExpression struct {
 	Token    token.Token
 	Operator string
@@ -162,6 +260,16 @@ func (pe *PrefixExpression) TokenLiteral() string {
 	return pe.Token.Literal
 }
 
+// Pos ...
+func (pe *PrefixExpression) Pos() token.Position {
+	return pe.Token.Pos
+}
+
+// End ...
+func (pe *PrefixExpression) End() token.Position {
+	return pe.Right.End()
+}
+
 // String ...
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
@@ -172,6 +280,29 @@ func (pe *PrefixExpression) String() string {
 	return out.String()
 }
 
+// AssignExpression ...
+// "x = 5" "x += 1",表达式的值就是赋给变量的值
+type AssignExpression struct {
+	Token    token.Token // = += -= *= /=
+	Name     *Identifier
+	Operator string
+	Value    Expression
+}
+
+func (ae *AssignExpression) expressionNode()      {}
+func (ae *AssignExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AssignExpression) Pos() token.Position  { return ae.Token.Pos }
+func (ae *AssignExpression) End() token.Position  { return ae.Value.End() }
+func (ae *AssignExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(ae.Name.String())
+	out.WriteString(" " + ae.Operator + " ")
+	out.WriteString(ae.Value.String())
+
+	return out.String()
+}
+
 type InfixExpression struct {
 	Token    token.Token
 	Left     Expression
@@ -188,6 +319,16 @@ func (ie *InfixExpression) TokenLiteral() string {
 	return ie.Token.Literal
 }
 
+// Pos ...
+func (ie *InfixExpression) Pos() token.Position {
+	return ie.Token.Pos
+}
+
+// End ...
+func (ie *InfixExpression) End() token.Position {
+	return ie.Right.End()
+}
+
 // String ...
 func (ie *InfixExpression) String() string {
 	var out bytes.Buffer
@@ -206,15 +347,28 @@ type Boolean struct {
 
 func (b *Boolean) expressionNode()      {}
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
+func (b *Boolean) Pos() token.Position  { return b.Token.Pos }
+func (b *Boolean) End() token.Position  { return b.Token.Pos.Advance(b.Token.Literal) }
 func (b *Boolean) String() string       { return b.Token.Literal }
 
 type BlockStatement struct {
 	Token      token.Token // '{'词法单元
 	Statements []Statement
+	Rbrace     token.Position //"}" 的位置
 }
 
 func (bs *BlockStatement) statementNode()       {}
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BlockStatement) Pos() token.Position  { return bs.Token.Pos }
+func (bs *BlockStatement) End() token.Position {
+	if bs.Rbrace.IsValid() {
+		return bs.Rbrace.Advance("}")
+	}
+	if len(bs.Statements) > 0 {
+		return bs.Statements[len(bs.Statements)-1].End()
+	}
+	return bs.Token.Pos.Advance(bs.Token.Literal)
+}
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
 	for _, s := range bs.Statements {
@@ -233,6 +387,13 @@ type IfExpression struct {
 // expressionNode ...
 func (ie *IfExpression) expressionNode()      {}
 func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IfExpression) Pos() token.Position  { return ie.Token.Pos }
+func (ie *IfExpression) End() token.Position {
+	if ie.Alternative != nil {
+		return ie.Alternative.End()
+	}
+	return ie.Consequence.End()
+}
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 
@@ -262,6 +423,8 @@ type FunctionLiteral struct {
 // expressionNode ...
 func (fl *FunctionLiteral) expressionNode()      {}
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FunctionLiteral) Pos() token.Position  { return fl.Token.Pos }
+func (fl *FunctionLiteral) End() token.Position  { return fl.Body.End() }
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
@@ -286,10 +449,13 @@ type CallExpression struct {
 	Token     token.Token
 	Function  Expression
 	Arguments []Expression
+	Rparen    token.Position //")" 的位置
 }
 
 func (ce *CallExpression) expressionNode()      {}
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) Pos() token.Position  { return ce.Token.Pos }
+func (ce *CallExpression) End() token.Position  { return ce.Rparen.Advance(")") }
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 
@@ -319,6 +485,16 @@ func (sl *StringLiteral) TokenLiteral() string {
 	return sl.Token.Literal
 }
 
+// Pos ...
+func (sl *StringLiteral) Pos() token.Position {
+	return sl.Token.Pos
+}
+
+// End ...
+func (sl *StringLiteral) End() token.Position {
+	return sl.Token.Pos.Advance(`"` + sl.Token.Literal + `"`)
+}
+
 // String ...
 func (sl *StringLiteral) String() string {
 	return sl.Token.Literal
@@ -327,6 +503,7 @@ func (sl *StringLiteral) String() string {
 type ArrayLiteral struct {
 	Token    token.Token
 	Elements []Expression
+	Rbracket token.Position //"]" 的位置
 }
 
 // expressionNode ...
@@ -337,6 +514,16 @@ func (al *ArrayLiteral) TokenLiteral() string {
 	return al.Token.Literal
 }
 
+// Pos ...
+func (al *ArrayLiteral) Pos() token.Position {
+	return al.Token.Pos
+}
+
+// End ...
+func (al *ArrayLiteral) End() token.Position {
+	return al.Rbracket.Advance("]")
+}
+
 // String ...
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
@@ -354,9 +541,10 @@ func (al *ArrayLiteral) String() string {
 }
 
 type IndexExpression struct {
-	Token token.Token
-	Left  Expression
-	Index Expression
+	Token    token.Token
+	Left     Expression
+	Index    Expression
+	Rbracket token.Position //"]" 的位置
 }
 
 // expressionNode ...
@@ -367,6 +555,16 @@ func (ie *IndexExpression) TokenLiteral() string {
 	return ie.Token.Literal
 }
 
+// Pos ...
+func (ie *IndexExpression) Pos() token.Position {
+	return ie.Token.Pos
+}
+
+// End ...
+func (ie *IndexExpression) End() token.Position {
+	return ie.Rbracket.Advance("]")
+}
+
 // String ...
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
@@ -379,9 +577,37 @@ func (ie *IndexExpression) String() string {
 	return out.String()
 }
 
+// IndexAssignExpression ...
+// "arr[0] = 5" "h["k"] += 1",直接修改数组或哈希表,表达式的值是赋进去的值
+type IndexAssignExpression struct {
+	Token    token.Token // = += -= *= /=
+	Left     Expression
+	Index    Expression
+	Operator string
+	Value    Expression
+}
+
+func (ia *IndexAssignExpression) expressionNode()      {}
+func (ia *IndexAssignExpression) TokenLiteral() string { return ia.Token.Literal }
+func (ia *IndexAssignExpression) Pos() token.Position  { return ia.Token.Pos }
+func (ia *IndexAssignExpression) End() token.Position  { return ia.Value.End() }
+func (ia *IndexAssignExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(ia.Left.String())
+	out.WriteString("[")
+	out.WriteString(ia.Index.String())
+	out.WriteString("]")
+	out.WriteString(" " + ia.Operator + " ")
+	out.WriteString(ia.Value.String())
+
+	return out.String()
+}
+
 type HashLiteral struct {
-	Token token.Token
-	Pairs map[Expression]Expression
+	Token  token.Token
+	Pairs  map[Expression]Expression
+	Rbrace token.Position //"}" 的位置
 }
 
 // expressionNode ...
@@ -392,6 +618,16 @@ func (hl *HashLiteral) TokenLiteral() string {
 	return hl.Token.Literal
 }
 
+// Pos ...
+func (hl *HashLiteral) Pos() token.Position {
+	return hl.Token.Pos
+}
+
+// End ...
+func (hl *HashLiteral) End() token.Position {
+	return hl.Rbrace.Advance("}")
+}
+
 // String ...
 func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
@@ -419,6 +655,16 @@ func (ml *MacroLiteral) TokenLiteral() string {
 	return ml.Token.Literal
 }
 
+// Pos ...
+func (ml *MacroLiteral) Pos() token.Position {
+	return ml.Token.Pos
+}
+
+// End ...
+func (ml *MacroLiteral) End() token.Position {
+	return ml.Body.End()
+}
+
 // String ...
 func (ml *MacroLiteral) String() string {
 	var out bytes.Buffer
@@ -435,3 +681,74 @@ func (ml *MacroLiteral) String() string {
 	out.WriteString(ml.Body.String())
 	return out.String()
 }
+
+//	while (x > 0) {
+//	  x = x - 1;
+//	}
+type WhileStatement struct {
+	Token     token.Token // while
+	Condition Expression
+	Body      *BlockStatement
+}
+
+func (ws *WhileStatement) StatementNode()       {}
+func (ws *WhileStatement) TokenLiteral() string { return ws.Token.Literal }
+func (ws *WhileStatement) Pos() token.Position  { return ws.Token.Pos }
+func (ws *WhileStatement) End() token.Position  { return ws.Body.End() }
+func (ws *WhileStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("while")
+	out.WriteString(ws.Condition.String())
+	out.WriteString(" ")
+	out.WriteString(ws.Body.String())
+
+	return out.String()
+}
+
+//	for (x in [1, 2, 3]) {
+//	  puts(x);
+//	}
+type ForStatement struct {
+	Token    token.Token // for
+	Variable *Identifier
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (fs *ForStatement) StatementNode()       {}
+func (fs *ForStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *ForStatement) Pos() token.Position  { return fs.Token.Pos }
+func (fs *ForStatement) End() token.Position  { return fs.Body.End() }
+func (fs *ForStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("for (")
+	out.WriteString(fs.Variable.String())
+	out.WriteString(" in ")
+	out.WriteString(fs.Iterable.String())
+	out.WriteString(") ")
+	out.WriteString(fs.Body.String())
+
+	return out.String()
+}
+
+type BreakStatement struct {
+	Token token.Token // break
+}
+
+func (bs *BreakStatement) StatementNode()       {}
+func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BreakStatement) Pos() token.Position  { return bs.Token.Pos }
+func (bs *BreakStatement) End() token.Position  { return bs.Token.Pos.Advance(bs.Token.Literal) }
+func (bs *BreakStatement) String() string       { return "break;" }
+
+type ContinueStatement struct {
+	Token token.Token // continue
+}
+
+func (cs *ContinueStatement) StatementNode()       {}
+func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
+func (cs *ContinueStatement) Pos() token.Position  { return cs.Token.Pos }
+func (cs *ContinueStatement) End() token.Position  { return cs.Token.Pos.Advance(cs.Token.Literal) }
+func (cs *ContinueStatement) String() string       { return "continue;" }
@@ -121,6 +121,52 @@ func TestModify(t *testing.T) {
 			&ArrayLiteral{Elements: []Expression{one(),one()}},
 			&ArrayLiteral{Elements: []Expression{two(),two()}},
 		},
+		{
+			&IndexAssignExpression{Left: one(), Index: one(), Operator: "=", Value: one()},
+			&IndexAssignExpression{Left: two(), Index: two(), Operator: "=", Value: two()},
+		},
+		{
+			&WhileStatement{
+				Condition: one(),
+				Body: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: one()},
+					},
+				},
+			},
+			&WhileStatement{
+				Condition: two(),
+				Body: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: two()},
+					},
+				},
+			},
+		},
+		{
+			&ForStatement{
+				Variable: &Identifier{Value: "x"},
+				Iterable: &ArrayLiteral{Elements: []Expression{one()}},
+				Body: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: one()},
+					},
+				},
+			},
+			&ForStatement{
+				Variable: &Identifier{Value: "x"},
+				Iterable: &ArrayLiteral{Elements: []Expression{two()}},
+				Body: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: two()},
+					},
+				},
+			},
+		},
+		{
+			&CallExpression{Function: &Identifier{Value: "f"}, Arguments: []Expression{one(), one()}},
+			&CallExpression{Function: &Identifier{Value: "f"}, Arguments: []Expression{two(), two()}},
+		},
 	}
 	for _, tt := range tests {
 		modified := Modify(tt.input, turnOneIntoTwo)
@@ -0,0 +1,117 @@
+package ast
+
+import (
+	"fmt"
+	"io"
+	"monkey/token"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Fprint 把以 node 为根的语法树逐行缩进打印到 w。
+// 每一行是节点类型、位置以及标量字段,子节点在下一层缩进里
+func Fprint(w io.Writer, node Node) error {
+	p := &printer{w: w}
+	p.print("", reflect.ValueOf(node), 0)
+	return p.err
+}
+
+type printer struct {
+	w   io.Writer
+	err error
+}
+
+var nodeType = reflect.TypeOf((*Node)(nil)).Elem()
+
+func (p *printer) printf(indent int, format string, a ...interface{}) {
+	if p.err != nil {
+		return
+	}
+	_, p.err = fmt.Fprintf(p.w, "%s%s\n", strings.Repeat("  ", indent), fmt.Sprintf(format, a...))
+}
+
+func (p *printer) print(label string, v reflect.Value, indent int) {
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	if !v.IsValid() || (v.Kind() == reflect.Ptr && v.IsNil()) {
+		p.printf(indent, "%snil", label)
+		return
+	}
+
+	node, ok := v.Interface().(Node)
+	if !ok || v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		p.printf(indent, "%s%v", label, v.Interface())
+		return
+	}
+
+	s := v.Elem()
+	header := []string{label + v.Type().String()}
+	if pos := node.Pos(); pos.IsValid() {
+		header = append(header, pos.String())
+	}
+	for i := 0; i < s.NumField(); i++ {
+		f := s.Type().Field(i)
+		if f.Name == "Token" || !isScalar(f.Type) {
+			continue
+		}
+		if f.Type.Kind() == reflect.String {
+			header = append(header, fmt.Sprintf("%s=%q", f.Name, s.Field(i).String()))
+		} else {
+			header = append(header, fmt.Sprintf("%s=%v", f.Name, s.Field(i).Interface()))
+		}
+	}
+	p.printf(indent, "%s", strings.Join(header, " "))
+
+	for i := 0; i < s.NumField(); i++ {
+		f := s.Type().Field(i)
+		if f.Name == "Token" || f.Type == positionType || isScalar(f.Type) {
+			continue
+		}
+		p.printField(f.Name, s.Field(i), indent+1)
+	}
+}
+
+func (p *printer) printField(name string, v reflect.Value, indent int) {
+	switch v.Kind() {
+	case reflect.Slice:
+		if v.Len() == 0 {
+			p.printf(indent, "%s: []", name)
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			p.print(fmt.Sprintf("%s[%d]: ", name, i), v.Index(i), indent)
+		}
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		if len(keys) == 0 {
+			p.printf(indent, "%s: {}", name)
+			return
+		}
+		for i, k := range keys {
+			p.print(fmt.Sprintf("%s[%d] key: ", name, i), k, indent)
+			p.print(fmt.Sprintf("%s[%d] value: ", name, i), v.MapIndex(k), indent)
+		}
+	default:
+		p.print(name+": ", v, indent)
+	}
+}
+
+// positionType Rbrace 这类结束位置的字段不打印,节点的位置已经在头部打印过了
+var positionType = reflect.TypeOf(token.Position{})
+
+// isScalar 字符串、数字、布尔这类字段直接打印在节点所在的行
+func isScalar(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
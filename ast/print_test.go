@@ -0,0 +1,51 @@
+package ast
+
+import (
+	"bytes"
+	"monkey/token"
+	"testing"
+)
+
+func TestFprint(t *testing.T) {
+	program := &Program{
+		Statements: []Statement{
+			&LetStatement{
+				Token: token.Token{Type: token.LET, Literal: "let", Pos: token.Position{Line: 1, Column: 1}},
+				Name: &Identifier{
+					Token: token.Token{Type: token.IDENT, Literal: "x", Pos: token.Position{Line: 1, Column: 5}},
+					Value: "x",
+				},
+				Value: &PrefixExpression{
+					Token:    token.Token{Type: token.MINUS, Literal: "-", Pos: token.Position{Line: 1, Column: 9}},
+					Operator: "-",
+					Right: &IntegerLiteral{
+						Token: token.Token{Type: token.INT, Literal: "5", Pos: token.Position{Line: 1, Column: 10}},
+						Value: 5,
+					},
+				},
+			},
+			&ExpressionStatement{
+				Expression: &ArrayLiteral{},
+			},
+		},
+	}
+
+	expected := `*ast.Program 1:1
+  Statements[0]: *ast.LetStatement 1:1
+    Name: *ast.Identifier 1:5 Value="x"
+    Value: *ast.PrefixExpression 1:9 Operator="-"
+      Right: *ast.IntegerLiteral 1:10 Value=5
+  Statements[1]: *ast.ExpressionStatement
+    Expression: *ast.ArrayLiteral
+      Elements: []
+`
+
+	var out bytes.Buffer
+	err := Fprint(&out, program)
+	if err != nil {
+		t.Fatalf("Fprint returned error: %s", err)
+	}
+	if out.String() != expected {
+		t.Errorf("Fprint wrong.\nwant=\n%s\ngot=\n%s", expected, out.String())
+	}
+}
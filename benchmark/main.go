@@ -14,6 +14,7 @@ import (
 )
 
 var engine = flag.String("engine", "vm", "use 'vm' or 'eval'")
+var optimizationLevel = flag.Int("O", compiler.OptimizePeephole, "compiler optimization level for the vm")
 
 var input = `
 let fibonacci = fn(x) {
@@ -42,6 +43,7 @@ func main() {
 
     if *engine == "vm" {
         comp := compiler.New()
+        comp.SetOptimizationLevel(*optimizationLevel)
         err := comp.Compile(program)
         if err != nil {
             fmt.Printf("compiler error: %s", err)
@@ -68,8 +70,9 @@ func main() {
     }
 
     fmt.Printf(
-        "engine=%s, result=%s, duration=%s\n",
+        "engine=%s, O=%d, result=%s, duration=%s\n",
         *engine,
+        *optimizationLevel,
         result.Inspect(),
         duration)
 }
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// buildCommand 执行 `monkey build <file> [-o out]`,把源文件编译成字节码文件。
+// 默认输出到同名的 .mkc 文件
+func buildCommand(args []string) int {
+	fs := flag.NewFlagSet("build", flag.ContinueOnError)
+	output := fs.String("o", "", "output file (default: <file> with the extension .mkc)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() < 1 {
+		fmt.Fprintf(os.Stderr, "build: missing file name\n")
+		return 2
+	}
+	filename := fs.Arg(0)
+	// 允许把 -o 写在文件名后面
+	if err := fs.Parse(fs.Args()[1:]); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "build: unexpected arguments %v\n", fs.Args())
+		return 2
+	}
+	if *output == "" {
+		*output = strings.TrimSuffix(filename, ".mk") + ".mkc"
+	}
+
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "build: %s\n", err)
+		return 1
+	}
+	var buf bytes.Buffer
+	if status := buildSource(os.Stderr, &buf, filename, string(source)); status != 0 {
+		return status
+	}
+	if err := os.WriteFile(*output, buf.Bytes(), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "build: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// buildSource 编译源文件并把字节码写到 out
+func buildSource(errOut, out io.Writer, filename, source string) int {
+	program, ok := parseSource(errOut, filename, source)
+	if !ok {
+		return 1
+	}
+	bytecode, err := compileProgram(program)
+	if err != nil {
+		fmt.Fprintf(errOut, "compile error: %s\n", err)
+		return 1
+	}
+	if err := bytecode.Serialize(out); err != nil {
+		fmt.Fprintf(errOut, "build: %s\n", err)
+		return 1
+	}
+	return 0
+}
@@ -75,6 +75,43 @@ const (
 	OpGetFree
 
 	OpCurrentClosure
+
+	// >= ,<= 通过交换操作数也编译成这条指令
+	OpGreaterEqual
+	// %
+	OpMod
+
+	// 弹出数组或哈希表,压入一个迭代器
+	OpIter
+	// 迭代器还有元素时压入下一个元素,否则弹出迭代器并跳转到操作数的位置
+	OpIterNext
+
+	// 给自由变量赋值
+	OpSetFree
+	// 创建闭包时捕获会被赋值的局部变量/自由变量,压入共享的存储单元而不是值的副本
+	OpCaptureLocal
+	OpCaptureFree
+
+	// 弹出值、下标和数组/哈希表,原地修改以后把值压回栈上
+	OpSetIndex
+	// 复制栈顶的 n 个元素,复合赋值 "a[i] += 1" 用它来重复使用 a 和 i
+	OpDup
+	// 尾位置上的调用,被调用的是闭包时复用当前的帧
+	OpTailCall
+
+	// 下面是窥孔优化合并出来的超级指令,编译器本身不会直接发出
+	// OpGetLocal i; OpConstant c; OpAdd
+	OpAddLocalConstant
+	// OpGetLocal i; OpConstant c; OpSub
+	OpSubLocalConstant
+	// OpEqual; OpJumpNotTruthy
+	OpJumpNotEqual
+	// OpNotEqual; OpJumpNotTruthy
+	OpJumpEqual
+	// OpGreaterThan; OpJumpNotTruthy
+	OpJumpNotGreater
+	// OpGreaterEqual; OpJumpNotTruthy
+	OpJumpNotGreaterEqual
 )
 
 // 定义：名字 操作符占用字符数
@@ -114,6 +151,33 @@ var definitions = map[Opcode]*Definition{
 	OpClosure:       {"OpClosure", []int{2, 1}},
 	OpGetFree:       {"OpGetFree", []int{1}},
 	OpCurrentClosure: {"OpCurrentClosure",[]int{}},
+	OpGreaterEqual:   {"OpGreaterEqual", []int{}},
+	OpMod:            {"OpMod", []int{}},
+	OpIter:           {"OpIter", []int{}},
+	OpIterNext:       {"OpIterNext", []int{2}},
+	OpSetFree:        {"OpSetFree", []int{1}},
+	OpCaptureLocal:   {"OpCaptureLocal", []int{1}},
+	OpCaptureFree:    {"OpCaptureFree", []int{1}},
+	OpSetIndex:       {"OpSetIndex", []int{}},
+	OpDup:            {"OpDup", []int{1}},
+	OpTailCall:       {"OpTailCall", []int{1}},
+
+	OpAddLocalConstant:    {"OpAddLocalConstant", []int{1, 2}},
+	OpSubLocalConstant:    {"OpSubLocalConstant", []int{1, 2}},
+	OpJumpNotEqual:        {"OpJumpNotEqual", []int{2}},
+	OpJumpEqual:           {"OpJumpEqual", []int{2}},
+	OpJumpNotGreater:      {"OpJumpNotGreater", []int{2}},
+	OpJumpNotGreaterEqual: {"OpJumpNotGreaterEqual", []int{2}},
+}
+
+// IsJump 第一个操作数是跳转目标的指令
+func IsJump(op Opcode) bool {
+	switch op {
+	case OpJump, OpJumpNotTruthy, OpIterNext,
+		OpJumpNotEqual, OpJumpEqual, OpJumpNotGreater, OpJumpNotGreaterEqual:
+		return true
+	}
+	return false
 }
 
 // Lookup ...
@@ -208,6 +272,40 @@ func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
 	return operands, offset
 }
 
+// DecodeError 指令无法解码,Offset 是这条指令的偏移量
+type DecodeError struct {
+	Offset int
+	Reason string
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("offset %d: %s", e.Offset, e.Reason)
+}
+
+// Walk 依次解码每条指令交给 f,pos 是指令的偏移量。遇到未定义或者被截断的指令时停下,
+// 返回 *DecodeError;f 返回错误时也停下,原样返回这个错误
+func Walk(ins Instructions, f func(pos int, op Opcode, operands []int) error) error {
+	for pos := 0; pos < len(ins); {
+		def, err := Lookup(ins[pos])
+		if err != nil {
+			return &DecodeError{Offset: pos, Reason: err.Error()}
+		}
+		width := 0
+		for _, w := range def.OperandWidths {
+			width += w
+		}
+		if pos+1+width > len(ins) {
+			return &DecodeError{Offset: pos, Reason: "truncated " + def.Name}
+		}
+		operands, read := ReadOperands(def, ins[pos+1:])
+		if err := f(pos, Opcode(ins[pos]), operands); err != nil {
+			return err
+		}
+		pos += 1 + read
+	}
+	return nil
+}
+
 // ReadUint16 是一个函数，用于将字节切片转换为 16 位无符号整数
 func ReadUint16(ins Instructions) uint16 {
 	return binary.BigEndian.Uint16(ins)
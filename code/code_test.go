@@ -92,3 +92,44 @@ func TestReadOperands(t *testing.T) {
 		}
 	}
 }
+
+func TestWalk(t *testing.T) {
+	valid := Instructions{}
+	valid = append(valid, Make(OpConstant, 1)...)
+	valid = append(valid, Make(OpGetLocal, 2)...)
+	valid = append(valid, Make(OpAdd)...)
+
+	tests := []struct {
+		ins       Instructions
+		positions []int
+		err       string
+	}{
+		{valid, []int{0, 3, 5}, ""},
+		{append(Make(OpAdd), 255), []int{0}, "offset 1: opcode 255 undefined"},
+		{append(Make(OpPop), byte(OpConstant), 0), []int{0}, "offset 1: truncated OpConstant"},
+	}
+
+	for _, tt := range tests {
+		var positions []int
+		err := Walk(tt.ins, func(pos int, op Opcode, operands []int) error {
+			positions = append(positions, pos)
+			return nil
+		})
+		if tt.err == "" && err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		if tt.err != "" {
+			if _, ok := err.(*DecodeError); !ok || err.Error() != tt.err {
+				t.Errorf("wrong error. want=%q, got=%v", tt.err, err)
+			}
+		}
+		if len(positions) != len(tt.positions) {
+			t.Fatalf("wrong positions. want=%v, got=%v", tt.positions, positions)
+		}
+		for i, pos := range tt.positions {
+			if positions[i] != pos {
+				t.Errorf("wrong positions. want=%v, got=%v", tt.positions, positions)
+			}
+		}
+	}
+}
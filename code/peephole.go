@@ -0,0 +1,123 @@
+package code
+
+// peepholeRule 把连续的几条指令合并成一条超级指令
+type peepholeRule struct {
+	pattern []Opcode
+	fused   Opcode
+	// 合并后的指令使用序列里第几条指令的源码范围,也就是运行时可能出错的那一条
+	rangeFrom int
+}
+
+var peepholeRules = []peepholeRule{
+	{[]Opcode{OpGetLocal, OpConstant, OpAdd}, OpAddLocalConstant, 2},
+	{[]Opcode{OpGetLocal, OpConstant, OpSub}, OpSubLocalConstant, 2},
+	{[]Opcode{OpEqual, OpJumpNotTruthy}, OpJumpNotEqual, 0},
+	{[]Opcode{OpNotEqual, OpJumpNotTruthy}, OpJumpEqual, 0},
+	{[]Opcode{OpGreaterThan, OpJumpNotTruthy}, OpJumpNotGreater, 0},
+	{[]Opcode{OpGreaterEqual, OpJumpNotTruthy}, OpJumpNotGreaterEqual, 0},
+}
+
+// decodedInstruction 解码后的一条指令
+type decodedInstruction struct {
+	offset   int
+	op       Opcode
+	operands []int
+}
+
+// Peephole 把常见的指令序列替换成超级指令,跳转目标和源码映射跟着调整。
+// 序列中间的指令如果是跳转目标就不合并。指令无法解码时原样返回
+func Peephole(ins Instructions, sourceMap SourceMap) (Instructions, SourceMap) {
+	decoded, ok := decodeInstructions(ins)
+	if !ok {
+		return ins, sourceMap
+	}
+
+	targets := map[int]bool{}
+	for _, d := range decoded {
+		if IsJump(d.op) {
+			targets[d.operands[0]] = true
+		}
+	}
+
+	type rewritten struct {
+		decodedInstruction
+		// 源码范围取自原来的哪个偏移量
+		rangeOffset int
+	}
+	var out []rewritten
+	for i := 0; i < len(decoded); {
+		rule, ok := matchPeephole(decoded[i:], targets)
+		if !ok {
+			out = append(out, rewritten{decoded[i], decoded[i].offset})
+			i++
+			continue
+		}
+		var operands []int
+		for _, d := range decoded[i : i+len(rule.pattern)] {
+			operands = append(operands, d.operands...)
+		}
+		fused := decodedInstruction{offset: decoded[i].offset, op: rule.fused, operands: operands}
+		out = append(out, rewritten{fused, decoded[i+rule.rangeFrom].offset})
+		i += len(rule.pattern)
+	}
+
+	// 原来的偏移量 -> 新的偏移量
+	newOffsets := map[int]int{}
+	length := 0
+	for _, r := range out {
+		newOffsets[r.offset] = length
+		length += 1
+		for _, w := range definitions[r.op].OperandWidths {
+			length += w
+		}
+	}
+	newOffsets[len(ins)] = length
+
+	result := make(Instructions, 0, length)
+	newSourceMap := SourceMap{}
+	for _, r := range out {
+		if IsJump(r.op) {
+			r.operands[0] = newOffsets[r.operands[0]]
+		}
+		if rng, ok := sourceMap.Lookup(r.rangeOffset); ok {
+			n := len(newSourceMap)
+			if n == 0 || newSourceMap[n-1].Range != rng {
+				newSourceMap = append(newSourceMap, SourceMapEntry{Offset: len(result), Range: rng})
+			}
+		}
+		result = append(result, Make(r.op, r.operands...)...)
+	}
+	return result, newSourceMap
+}
+
+// matchPeephole 找到能从 decoded[0] 开始合并的规则
+func matchPeephole(decoded []decodedInstruction, targets map[int]bool) (peepholeRule, bool) {
+	for _, rule := range peepholeRules {
+		if len(decoded) < len(rule.pattern) {
+			continue
+		}
+		matched := true
+		for j, op := range rule.pattern {
+			if decoded[j].op != op || (j > 0 && targets[decoded[j].offset]) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return rule, true
+		}
+	}
+	return peepholeRule{}, false
+}
+
+func decodeInstructions(ins Instructions) ([]decodedInstruction, bool) {
+	var decoded []decodedInstruction
+	err := Walk(ins, func(pos int, op Opcode, operands []int) error {
+		decoded = append(decoded, decodedInstruction{pos, op, operands})
+		return nil
+	})
+	if err != nil {
+		return nil, false
+	}
+	return decoded, true
+}
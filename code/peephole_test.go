@@ -0,0 +1,145 @@
+package code
+
+import (
+	"monkey/token"
+	"reflect"
+	"testing"
+)
+
+func concat(ins ...[]byte) Instructions {
+	out := Instructions{}
+	for _, i := range ins {
+		out = append(out, i...)
+	}
+	return out
+}
+
+func line(n int) token.Range {
+	return token.Range{Start: token.Position{Line: n, Column: 1}}
+}
+
+func TestPeephole(t *testing.T) {
+	tests := []struct {
+		name              string
+		input             Instructions
+		expected          Instructions
+		sourceMap         SourceMap
+		expectedSourceMap SourceMap
+	}{
+		{
+			"add constant to local",
+			concat(
+				Make(OpGetLocal, 1),
+				Make(OpConstant, 2),
+				Make(OpAdd),
+				Make(OpReturnValue),
+			),
+			concat(
+				Make(OpAddLocalConstant, 1, 2),
+				Make(OpReturnValue),
+			),
+			SourceMap{{0, line(1)}, {2, line(2)}, {5, line(3)}},
+			// 合并后的指令使用 OpAdd 的源码范围
+			SourceMap{{0, line(3)}},
+		},
+		{
+			"compare and branch",
+			concat(
+				// 0000
+				Make(OpGetLocal, 0),
+				// 0002
+				Make(OpConstant, 0),
+				// 0005
+				Make(OpEqual),
+				// 0006
+				Make(OpJumpNotTruthy, 13),
+				// 0009
+				Make(OpTrue),
+				// 0010
+				Make(OpJump, 14),
+				// 0013
+				Make(OpFalse),
+				// 0014
+				Make(OpGreaterThan),
+				Make(OpJumpNotTruthy, 14),
+			),
+			concat(
+				// 0000
+				Make(OpGetLocal, 0),
+				// 0002
+				Make(OpConstant, 0),
+				// 0005
+				Make(OpJumpNotEqual, 12),
+				// 0008
+				Make(OpTrue),
+				// 0009
+				Make(OpJump, 13),
+				// 0012
+				Make(OpFalse),
+				// 0013
+				Make(OpJumpNotGreater, 13),
+			),
+			SourceMap{{0, line(1)}, {5, line(2)}, {9, line(3)}, {14, line(4)}},
+			SourceMap{{0, line(1)}, {5, line(2)}, {8, line(3)}, {13, line(4)}},
+		},
+		{
+			"jump target at start of pattern",
+			concat(
+				// 0000
+				Make(OpTrue),
+				// 0001
+				Make(OpJumpNotTruthy, 7),
+				// 0004
+				Make(OpGetLocal, 0),
+				// 0006
+				Make(OpNull),
+				// 0007
+				Make(OpEqual),
+				// 0008
+				Make(OpJumpNotTruthy, 0),
+			),
+			concat(
+				Make(OpTrue),
+				Make(OpJumpNotTruthy, 7),
+				Make(OpGetLocal, 0),
+				Make(OpNull),
+				Make(OpJumpNotEqual, 0),
+			),
+			SourceMap{},
+			SourceMap{},
+		},
+		{
+			"jump target inside pattern",
+			concat(
+				// 0000
+				Make(OpGetLocal, 0),
+				// 0002
+				Make(OpConstant, 1),
+				// 0005
+				Make(OpAdd),
+				// 0006
+				Make(OpEqual),
+				// 0007
+				Make(OpJumpNotTruthy, 5),
+			),
+			concat(
+				Make(OpGetLocal, 0),
+				Make(OpConstant, 1),
+				Make(OpAdd),
+				Make(OpJumpNotEqual, 5),
+			),
+			SourceMap{},
+			SourceMap{},
+		},
+	}
+
+	for _, tt := range tests {
+		ins, sourceMap := Peephole(tt.input, tt.sourceMap)
+		if !reflect.DeepEqual(ins, tt.expected) {
+			t.Errorf("%s: wrong instructions.\nwant=\n%s\ngot=\n%s", tt.name, tt.expected, ins)
+		}
+		if !reflect.DeepEqual(sourceMap, tt.expectedSourceMap) {
+			t.Errorf("%s: wrong source map. want=%v, got=%v", tt.name, tt.expectedSourceMap, sourceMap)
+		}
+	}
+}
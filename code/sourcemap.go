@@ -0,0 +1,35 @@
+package code
+
+import "monkey/token"
+
+// SourceMapEntry 从 Offset 开始的指令由 Range 范围内的源码生成
+type SourceMapEntry struct {
+	Offset int
+	Range  token.Range
+}
+
+// SourceMap 按 Offset 从小到大排列,每一项一直管到下一项的 Offset 为止
+type SourceMap []SourceMapEntry
+
+// Lookup 找到 offset 处的指令对应的源码范围
+func (m SourceMap) Lookup(offset int) (token.Range, bool) {
+	found := -1
+	for i, entry := range m {
+		if entry.Offset > offset {
+			break
+		}
+		found = i
+	}
+	if found < 0 {
+		return token.Range{}, false
+	}
+	return m[found].Range, true
+}
+
+// Truncate 去掉 Offset 不小于 length 的项,指令被截短时使用
+func (m SourceMap) Truncate(length int) SourceMap {
+	for len(m) > 0 && m[len(m)-1].Offset >= length {
+		m = m[:len(m)-1]
+	}
+	return m
+}
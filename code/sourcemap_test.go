@@ -0,0 +1,45 @@
+package code
+
+import (
+	"monkey/token"
+	"testing"
+)
+
+func TestSourceMapLookup(t *testing.T) {
+	m := SourceMap{
+		{Offset: 0, Range: token.Range{Start: token.Position{Line: 1, Column: 1}}},
+		{Offset: 3, Range: token.Range{Start: token.Position{Line: 2, Column: 5}}},
+		{Offset: 7, Range: token.Range{Start: token.Position{Line: 3, Column: 1}}},
+	}
+
+	tests := []struct {
+		offset       int
+		expectedLine int
+	}{
+		{0, 1},
+		{2, 1},
+		{3, 2},
+		{6, 2},
+		{7, 3},
+		{100, 3},
+	}
+
+	for _, tt := range tests {
+		rng, ok := m.Lookup(tt.offset)
+		if !ok {
+			t.Fatalf("no range for offset %d", tt.offset)
+		}
+		if rng.Start.Line != tt.expectedLine {
+			t.Errorf("wrong line for offset %d. want=%d, got=%d", tt.offset, tt.expectedLine, rng.Start.Line)
+		}
+	}
+
+	if _, ok := (SourceMap{}).Lookup(0); ok {
+		t.Errorf("empty source map returned a range")
+	}
+
+	truncated := m.Truncate(7)
+	if len(truncated) != 2 {
+		t.Errorf("wrong length after Truncate. want=2, got=%d", len(truncated))
+	}
+}
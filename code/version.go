@@ -0,0 +1,20 @@
+package code
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// Version opcode 集合的版本号,由每条指令的编号、名字和操作数宽度算出来。
+// 增删或修改指令以后版本号就会变化,旧的字节码文件会被拒绝加载
+func Version() uint32 {
+	h := fnv.New32a()
+	for op := 0; op < 256; op++ {
+		def, ok := definitions[Opcode(op)]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(h, "%d %s %v;", op, def.Name, def.OperandWidths)
+	}
+	return h.Sum32()
+}
@@ -5,6 +5,7 @@ import (
 	"monkey/ast"
 	"monkey/code"
 	"monkey/object"
+	"monkey/token"
 	"sort"
 )
 
@@ -21,6 +22,20 @@ type CompilationScope struct {
 	lastInstruction EmittedInstruction
 	//倒数第二个
 	previousInstruction EmittedInstruction
+	//当前正在编译的循环,最内层的在最后
+	loops []*loopContext
+	//指令偏移量到源码位置的映射
+	sourceMap code.SourceMap
+}
+
+// 循环的跳转信息
+type loopContext struct {
+	//continue 跳转到的位置
+	continuePos int
+	//break 发出的 OpJump,循环编译完以后回填
+	breakJumps []int
+	//for-in 循环在栈上有一个迭代器,break 前要先弹出
+	hasIterator bool
 }
 
 type Compiler struct {
@@ -35,6 +50,20 @@ type Compiler struct {
 
 	scopes     []CompilationScope
 	scopeIndex int
+
+	//宏定义所在的环境,编译前的宏展开会用到
+	macroEnv *object.Environment
+	//展开宏时求值的限制,nil 表示不限制
+	macroLimits *object.Limits
+
+	//程序里会被重新赋值的变量名,闭包捕获这些变量时要共享存储而不是复制值
+	assignedNames map[string]bool
+
+	//正在编译的节点的源码范围,发出的指令都记在这个范围上
+	srcRange token.Range
+
+	//优化级别,见 SetOptimizationLevel
+	optimizationLevel int
 }
 
 func New() *Compiler {
@@ -52,6 +81,7 @@ func New() *Compiler {
 		symbolTable: symbolTable,
 		scopes:      []CompilationScope{mainScope},
 		scopeIndex:  0,
+		macroEnv:    object.NewEnvironment(),
 	}
 }
 func (c *Compiler) currentInstructions() code.Instructions {
@@ -89,6 +119,19 @@ func (c *Compiler) removeLastPop() {
 	new := old[:last.Position]
 	c.scopes[c.scopeIndex].instructions = new
 	c.scopes[c.scopeIndex].lastInstruction = previous
+	c.scopes[c.scopeIndex].sourceMap = c.scopes[c.scopeIndex].sourceMap.Truncate(len(new))
+}
+
+// addSourceRange 记录从 offset 开始的指令来自 c.srcRange,范围没有变化时不重复记录
+func (c *Compiler) addSourceRange(offset int) {
+	if !c.srcRange.Start.IsValid() {
+		return
+	}
+	scope := &c.scopes[c.scopeIndex]
+	if n := len(scope.sourceMap); n > 0 && scope.sourceMap[n-1].Range == c.srcRange {
+		return
+	}
+	scope.sourceMap = append(scope.sourceMap, code.SourceMapEntry{Offset: offset, Range: c.srcRange})
 }
 
 func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
@@ -116,12 +159,29 @@ func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
 // Compile ...
 // 递归遍历AST、找到*ast.IntegerLiterals、对其进行求值并将其转换为*object.Integers、将它们添加到常量字段、最后将OpConstant指令添加到内部的Instructions切片
 func (c *Compiler) Compile(node ast.Node) error {
+	if node.Pos().IsValid() {
+		outer := c.srcRange
+		c.srcRange = ast.Range(node)
+		defer func() { c.srcRange = outer }()
+	}
+
 	switch node := node.(type) {
 	case *ast.Program:
-		for _, s := range node.Statements {
+		node, err := c.expandMacros(node)
+		if err != nil {
+			return err
+		}
+		if c.optimizationLevel >= OptimizeBasic {
+			node = foldConstants(node).(*ast.Program)
+		}
+		c.assignedNames = collectAssignedNames(node)
+		for i, s := range node.Statements {
+			if c.optimizationLevel >= OptimizeBasic && isUnusedPureStatement(node.Statements, i) {
+				continue
+			}
 			err := c.Compile(s)
 			if err != nil {
-				return nil
+				return err
 			}
 		}
 	case *ast.ExpressionStatement:
@@ -131,7 +191,10 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 		c.emit(code.OpPop)
 	case *ast.InfixExpression:
-		if node.Operator == "<" {
+		if node.Operator == "&&" || node.Operator == "||" {
+			return c.compileLogicalExpression(node)
+		}
+		if node.Operator == "<" || node.Operator == "<=" {
 			err := c.Compile(node.Right)
 			if err != nil {
 				return err
@@ -140,7 +203,11 @@ func (c *Compiler) Compile(node ast.Node) error {
 			if err != nil {
 				return err
 			}
-			c.emit(code.OpGreaterThan)
+			if node.Operator == "<" {
+				c.emit(code.OpGreaterThan)
+			} else {
+				c.emit(code.OpGreaterEqual)
+			}
 			return nil
 		}
 		err := c.Compile(node.Left)
@@ -160,27 +227,38 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpMul)
 		case "/":
 			c.emit(code.OpDiv)
+		case "%":
+			c.emit(code.OpMod)
 		case ">":
 			c.emit(code.OpGreaterThan)
+		case ">=":
+			c.emit(code.OpGreaterEqual)
 		case "==":
 			c.emit(code.OpEqual)
 		case "!=":
 			c.emit(code.OpNotEqual)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return fmt.Errorf("%s: unknown operator %s", node.Pos(), node.Operator)
 		}
 	case *ast.PrefixExpression:
 		err := c.Compile(node.Right)
 		if err != nil {
-			return nil
+			return err
 		}
 		switch node.Operator {
 		case "!":
 			c.emit(code.OpBang)
 		case "-":
 			c.emit(code.OpMinus)
+		default:
+			return fmt.Errorf("%s: unknown operator %s", node.Pos(), node.Operator)
 		}
 	case *ast.IfExpression:
+		if c.optimizationLevel >= OptimizeBasic {
+			if branch, ok := constantBranch(node); ok {
+				return c.compileConstantBranch(branch)
+			}
+		}
 		err := c.Compile(node.Condition)
 		if err != nil {
 			return err
@@ -192,9 +270,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 
-		if c.lastInstructionIs(code.OpPop) {
-			c.removeLastPop()
-		}
+		c.finishBranch()
 		// 发出带有虚假偏移量的OpJump指令
 		jumpPos := c.emit(code.OpJump, 9999)
 		afterConsequencePos := len(c.currentInstructions())
@@ -206,22 +282,49 @@ func (c *Compiler) Compile(node ast.Node) error {
 			if err != nil {
 				return err
 			}
-			if c.lastInstructionIs(code.OpPop) {
-				c.removeLastPop()
-			}
+			c.finishBranch()
 		}
 		afterAlternativePos := len(c.currentInstructions())
 		c.changeOperand(jumpPos, afterAlternativePos)
 	case *ast.BlockStatement:
-		for _, s := range node.Statements {
+		for i, s := range node.Statements {
+			if c.optimizationLevel >= OptimizeBasic && isUnusedPureStatement(node.Statements, i) {
+				continue
+			}
 			err := c.Compile(s)
 			if err != nil {
 				return err
 			}
 		}
+	case *ast.AssignExpression:
+		return c.compileAssignExpression(node)
+	case *ast.IndexAssignExpression:
+		return c.compileIndexAssignExpression(node)
+	case *ast.WhileStatement:
+		return c.compileWhileStatement(node)
+	case *ast.ForStatement:
+		return c.compileForStatement(node)
+	case *ast.BreakStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			return fmt.Errorf("%s: break outside of a loop", node.Pos())
+		}
+		if loop.hasIterator {
+			c.emit(code.OpPop)
+		}
+		loop.breakJumps = append(loop.breakJumps, c.emit(code.OpJump, 9999))
+	case *ast.ContinueStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			return fmt.Errorf("%s: continue outside of a loop", node.Pos())
+		}
+		c.emit(code.OpJump, loop.continuePos)
 	case *ast.IntegerLiteral:
 		integer := &object.Integer{Value: node.Value}
 		c.emit(code.OpConstant, c.addConstant(integer))
+	case *ast.FloatLiteral:
+		float := &object.Float{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(float))
 	case *ast.StringLiteral:
 		str := &object.String{Value: node.Value}
 		c.emit(code.OpConstant, c.addConstant(str))
@@ -253,7 +356,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 	case *ast.Identifier:
 		symbol, ok := c.symbolTable.Resolve(node.Value)
 		if !ok {
-			return fmt.Errorf("undefined variable %s", node.Value)
+			return fmt.Errorf("%s: undefined variable %s", node.Pos(), node.Value)
 		}
 		c.loadSymbol(symbol)
 	case *ast.HashLiteral:
@@ -312,16 +415,24 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 		freeSymbols := c.symbolTable.FreeSymbols
 		numLocals := c.symbolTable.numDefinitions
+		sourceMap := c.scopes[c.scopeIndex].sourceMap
 		instructions := c.leaveScope()
+		// 尾调用不占用新的帧,决定了递归能有多深,所以不算优化,任何优化级别都要做
+		markTailCalls(instructions)
+		if c.optimizationLevel >= OptimizePeephole {
+			instructions, sourceMap = code.Peephole(instructions, sourceMap)
+		}
 
 		for _, s := range freeSymbols {
-			c.loadSymbol(s)
+			c.captureSymbol(s)
 		}
 
 		compiledFn := &object.CompiledFunction{
 			Instructions:  instructions,
 			NumLocals:     numLocals,
 			NumParameters: len(node.Parameters),
+			Name:          node.Name,
+			SourceMap:     sourceMap,
 		}
 		fnIndex := c.addConstant(compiledFn)
 		c.emit(code.OpClosure, fnIndex, len(freeSymbols))
@@ -331,7 +442,12 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 		c.emit(code.OpReturnValue)
+	case *ast.MacroLiteral:
+		return fmt.Errorf("%s: macro literals are only allowed in top-level let statements", node.Pos())
 	case *ast.CallExpression:
+		if node.Function.TokenLiteral() == "quote" || node.Function.TokenLiteral() == "unquote" {
+			return fmt.Errorf("%s: %s can only be used inside a macro", node.Pos(), node.Function.TokenLiteral())
+		}
 		err := c.Compile(node.Function)
 		if err != nil {
 			return err
@@ -347,18 +463,274 @@ func (c *Compiler) Compile(node ast.Node) error {
 	return nil
 }
 
+// compileLogicalExpression 用跳转实现短路求值,结果总是布尔值
+//
+//	a && b:  a; JumpNotTruthy F; b; JumpNotTruthy F; True; Jump E; F: False; E:
+//	a || b:  a; JumpNotTruthy R; True; Jump E; R: b; JumpNotTruthy F; True; Jump E; F: False; E:
+func (c *Compiler) compileLogicalExpression(node *ast.InfixExpression) error {
+	err := c.Compile(node.Left)
+	if err != nil {
+		return err
+	}
+
+	jumpsToFalse := []int{}
+	jumpsToEnd := []int{}
+
+	leftJumpPos := c.emit(code.OpJumpNotTruthy, 9999)
+	if node.Operator == "&&" {
+		jumpsToFalse = append(jumpsToFalse, leftJumpPos)
+	} else {
+		c.emit(code.OpTrue)
+		jumpsToEnd = append(jumpsToEnd, c.emit(code.OpJump, 9999))
+		c.changeOperand(leftJumpPos, len(c.currentInstructions()))
+	}
+
+	err = c.Compile(node.Right)
+	if err != nil {
+		return err
+	}
+	jumpsToFalse = append(jumpsToFalse, c.emit(code.OpJumpNotTruthy, 9999))
+	c.emit(code.OpTrue)
+	jumpsToEnd = append(jumpsToEnd, c.emit(code.OpJump, 9999))
+
+	falsePos := c.emit(code.OpFalse)
+	for _, pos := range jumpsToFalse {
+		c.changeOperand(pos, falsePos)
+	}
+	endPos := len(c.currentInstructions())
+	for _, pos := range jumpsToEnd {
+		c.changeOperand(pos, endPos)
+	}
+	return nil
+}
+
+// compileAssignExpression ...
+// 赋值表达式的值是赋给变量的值,所以赋值以后再把变量压回栈上
+func (c *Compiler) compileAssignExpression(node *ast.AssignExpression) error {
+	name := node.Name.Value
+	symbol, ok := c.symbolTable.Resolve(name)
+	if !ok {
+		return fmt.Errorf("%s: undefined variable %s", node.Pos(), name)
+	}
+	switch symbol.Scope {
+	case BuiltinScope:
+		return fmt.Errorf("%s: cannot assign to builtin %s", node.Pos(), name)
+	case FunctionScope:
+		return fmt.Errorf("%s: cannot assign to function name %s", node.Pos(), name)
+	}
+
+	if node.Operator != "=" {
+		c.loadSymbol(symbol)
+	}
+	err := c.Compile(node.Value)
+	if err != nil {
+		return err
+	}
+	c.emitCompoundOperator(node.Operator)
+
+	c.storeSymbol(symbol)
+	c.loadSymbol(symbol)
+	return nil
+}
+
+// compileIndexAssignExpression ...
+//
+//	a[i] = v:   a; i; v; SetIndex
+//	a[i] += v:  a; i; Dup 2; Index; v; Add; SetIndex
+func (c *Compiler) compileIndexAssignExpression(node *ast.IndexAssignExpression) error {
+	err := c.Compile(node.Left)
+	if err != nil {
+		return err
+	}
+	err = c.Compile(node.Index)
+	if err != nil {
+		return err
+	}
+
+	if node.Operator != "=" {
+		c.emit(code.OpDup, 2)
+		c.emit(code.OpIndex)
+	}
+	err = c.Compile(node.Value)
+	if err != nil {
+		return err
+	}
+	c.emitCompoundOperator(node.Operator)
+
+	c.emit(code.OpSetIndex)
+	return nil
+}
+
+// emitCompoundOperator "+=" 这类复合赋值在赋值之前先做对应的运算
+func (c *Compiler) emitCompoundOperator(operator string) {
+	switch operator {
+	case "+=":
+		c.emit(code.OpAdd)
+	case "-=":
+		c.emit(code.OpSub)
+	case "*=":
+		c.emit(code.OpMul)
+	case "/=":
+		c.emit(code.OpDiv)
+	}
+}
+
+// finishBranch if 的分支要在栈上留下一个值:
+// 以表达式结尾就去掉最后的 OpPop,否则(空块、let、循环等)压入 null
+func (c *Compiler) finishBranch() {
+	if c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	} else {
+		c.emit(code.OpNull)
+	}
+}
+
+// compileConstantBranch 条件是常量的 if 表达式只编译会执行的分支,不发出跳转
+func (c *Compiler) compileConstantBranch(branch *ast.BlockStatement) error {
+	if branch == nil {
+		c.emit(code.OpNull)
+		return nil
+	}
+	start := len(c.currentInstructions())
+	err := c.Compile(branch)
+	if err != nil {
+		return err
+	}
+	// 空的分支不能删掉前一条语句的 OpPop
+	if len(c.currentInstructions()) > start && c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	} else {
+		c.emit(code.OpNull)
+	}
+	return nil
+}
+
+// compileWhileStatement ...
+//
+//	L: cond; JumpNotTruthy E; body; Jump L; E:
+//
+// 打开优化时,条件恒为假的循环不编译,条件恒为真的循环不再检查条件
+func (c *Compiler) compileWhileStatement(node *ast.WhileStatement) error {
+	constant, truthy := false, false
+	if c.optimizationLevel >= OptimizeBasic {
+		truthy, constant = constantTruthiness(node.Condition)
+		if constant && !truthy && !declaresNames(node.Body) {
+			c.emitLoopValue()
+			return nil
+		}
+	}
+
+	conditionPos := len(c.currentInstructions())
+	jumpNotTruthyPos := -1
+	if !constant || !truthy {
+		err := c.Compile(node.Condition)
+		if err != nil {
+			return err
+		}
+		jumpNotTruthyPos = c.emit(code.OpJumpNotTruthy, 9999)
+	}
+
+	loop := c.enterLoop(conditionPos, false)
+	err := c.Compile(node.Body)
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpJump, conditionPos)
+
+	endPos := len(c.currentInstructions())
+	if jumpNotTruthyPos >= 0 {
+		c.changeOperand(jumpNotTruthyPos, endPos)
+	}
+	c.leaveLoop(loop, endPos)
+	c.emitLoopValue()
+	return nil
+}
+
+// compileForStatement ...
+//
+//	iterable; Iter; L: IterNext E; set x; body; Jump L; E:
+func (c *Compiler) compileForStatement(node *ast.ForStatement) error {
+	err := c.Compile(node.Iterable)
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpIter)
+
+	iterNextPos := c.emit(code.OpIterNext, 9999)
+	symbol := c.symbolTable.Define(node.Variable.Value)
+	if symbol.Scope == GlobalScope {
+		c.emit(code.OpSetGlobal, symbol.Index)
+	} else {
+		c.emit(code.OpSetLocal, symbol.Index)
+	}
+
+	loop := c.enterLoop(iterNextPos, true)
+	err = c.Compile(node.Body)
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpJump, iterNextPos)
+
+	endPos := len(c.currentInstructions())
+	c.changeOperand(iterNextPos, endPos)
+	c.leaveLoop(loop, endPos)
+	c.emitLoopValue()
+	return nil
+}
+
+// emitLoopValue 循环语句的值是 null。程序以循环结尾时,
+// LastPoppedStackElem 拿到的是它,而不是最后一次的循环条件或者迭代器
+func (c *Compiler) emitLoopValue() {
+	c.emit(code.OpNull)
+	c.emit(code.OpPop)
+}
+
+func (c *Compiler) currentLoop() *loopContext {
+	loops := c.scopes[c.scopeIndex].loops
+	if len(loops) == 0 {
+		return nil
+	}
+	return loops[len(loops)-1]
+}
+
+func (c *Compiler) enterLoop(continuePos int, hasIterator bool) *loopContext {
+	loop := &loopContext{continuePos: continuePos, hasIterator: hasIterator}
+	c.scopes[c.scopeIndex].loops = append(c.scopes[c.scopeIndex].loops, loop)
+	return loop
+}
+
+// leaveLoop 把循环里所有 break 的跳转目标回填为 endPos
+func (c *Compiler) leaveLoop(loop *loopContext, endPos int) {
+	for _, pos := range loop.breakJumps {
+		c.changeOperand(pos, endPos)
+	}
+	loops := c.scopes[c.scopeIndex].loops
+	c.scopes[c.scopeIndex].loops = loops[:len(loops)-1]
+}
+
 // Bytecode ...
 // 返回一个包含编译器内部指令和常量的*Bytecode结构体指针
 func (c *Compiler) Bytecode() *Bytecode {
+	instructions := c.currentInstructions()
+	sourceMap := c.scopes[c.scopeIndex].sourceMap
+	if c.optimizationLevel >= OptimizePeephole {
+		instructions, sourceMap = code.Peephole(instructions, sourceMap)
+	}
 	return &Bytecode{
-		Instructions: c.currentInstructions(),
+		Instructions: instructions,
 		Constants:    c.constants,
+		SourceMap:    sourceMap,
+		NumGlobals:   c.symbolTable.numDefinitions,
 	}
 }
 
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
+	//主程序指令对应的源码范围,函数的在各自的 CompiledFunction 里
+	SourceMap code.SourceMap
+	//定义过的全局变量的个数,指令里的全局变量下标都比它小
+	NumGlobals int
 }
 
 // 生成指令并将其添加到最终结果
@@ -366,6 +738,7 @@ func (c *Compiler) emit(op code.Opcode, operands ...int) int {
 	ins := code.Make(op, operands...)
 	pos := c.addInstruction(ins)
 	c.setLastInstruction(op, pos)
+	c.addSourceRange(pos)
 	return pos
 }
 
@@ -399,6 +772,69 @@ func (c *Compiler) replaceLastPopWithReturn() {
 	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
 }
 
+func (c *Compiler) storeSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpSetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpSetLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpSetFree, s.Index)
+	}
+}
+
+// captureSymbol 把闭包要捕获的变量压栈。
+// 会被赋值的变量压入共享的存储单元,这样闭包内外的修改互相可见
+func (c *Compiler) captureSymbol(s Symbol) {
+	if !c.assignedNames[s.Name] {
+		c.loadSymbol(s)
+		return
+	}
+	switch s.Scope {
+	case LocalScope:
+		c.emit(code.OpCaptureLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpCaptureFree, s.Index)
+	default:
+		c.loadSymbol(s)
+	}
+}
+
+// collectAssignedNames 找出程序里所有会被重新赋值的变量名:
+// 赋值表达式的目标、for-in 的循环变量、循环体里的 let 以及被 let 了不止一次的名字
+func collectAssignedNames(program *ast.Program) map[string]bool {
+	names := map[string]bool{}
+	letCount := map[string]int{}
+
+	collectLoopBody := func(body *ast.BlockStatement) {
+		ast.Modify(body, func(node ast.Node) ast.Node {
+			if let, ok := node.(*ast.LetStatement); ok {
+				names[let.Name.Value] = true
+			}
+			return node
+		})
+	}
+
+	ast.Modify(program, func(node ast.Node) ast.Node {
+		switch node := node.(type) {
+		case *ast.AssignExpression:
+			names[node.Name.Value] = true
+		case *ast.LetStatement:
+			letCount[node.Name.Value]++
+			if letCount[node.Name.Value] > 1 {
+				names[node.Name.Value] = true
+			}
+		case *ast.WhileStatement:
+			collectLoopBody(node.Body)
+		case *ast.ForStatement:
+			names[node.Variable.Value] = true
+			collectLoopBody(node.Body)
+		}
+		return node
+	})
+	return names
+}
+
 func (c *Compiler) loadSymbol(s Symbol) {
 	switch s.Scope {
 	case GlobalScope:
@@ -1,6 +1,8 @@
 package compiler
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"monkey/ast"
 	"monkey/code"
@@ -237,6 +239,23 @@ func runCompilerTests(t *testing.T, tests []compilerTestCase) {
 	}
 }
 
+func TestCompilerErrorPosition(t *testing.T) {
+	l := lexer.NewWithFilename("main.mk", "let a = 1;\nlet b = a + c;")
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	compiler := New()
+	err := compiler.Compile(program)
+	if err == nil {
+		t.Fatalf("expected compiler error but resulted in none.")
+	}
+
+	expected := "main.mk:2:13: undefined variable c"
+	if err.Error() != expected {
+		t.Fatalf("wrong compiler error: want=%q, got=%q", expected, err)
+	}
+}
+
 // parse ...
 func parse(input string) *ast.Program {
 	l := lexer.New(input)
@@ -288,6 +307,11 @@ func testConstants(
 			if err != nil {
 				return fmt.Errorf("constant %d - testIntegerObject failed:%s", i, err)
 			}
+		case float64:
+			err := testFloatObject(constant, actual[i])
+			if err != nil {
+				return fmt.Errorf("constant %d - testFloatObject failed:%s", i, err)
+			}
 		case string:
 			err := testStringObject(constant, actual[i])
 			if err != nil {
@@ -321,6 +345,17 @@ func testIntegerObject(expected int64, actual object.Object) error {
 	return nil
 }
 
+func testFloatObject(expected float64, actual object.Object) error {
+	result, ok := actual.(*object.Float)
+	if !ok {
+		return fmt.Errorf("object is not Float. got=%T (%+v)", actual, actual)
+	}
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%g, want=%g", result.Value, expected)
+	}
+	return nil
+}
+
 func testStringObject(expected string, actual object.Object) error {
 	result, ok := actual.(*object.String)
 	if !ok {
@@ -333,6 +368,124 @@ func testStringObject(expected string, actual object.Object) error {
 	return nil
 }
 
+func TestComparisonAndModulo(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 >= 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGreaterEqual),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 <= 2",
+			expectedConstants: []interface{}{2, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGreaterEqual),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "7 % 3",
+			expectedConstants: []interface{}{7, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMod),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestLogicalOperators(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "true && false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 12),
+				// 0004
+				code.Make(code.OpFalse),
+				// 0005
+				code.Make(code.OpJumpNotTruthy, 12),
+				// 0008
+				code.Make(code.OpTrue),
+				// 0009
+				code.Make(code.OpJump, 13),
+				// 0012
+				code.Make(code.OpFalse),
+				// 0013
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "true || false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 8),
+				// 0004
+				code.Make(code.OpTrue),
+				// 0005
+				code.Make(code.OpJump, 17),
+				// 0008
+				code.Make(code.OpFalse),
+				// 0009
+				code.Make(code.OpJumpNotTruthy, 16),
+				// 0012
+				code.Make(code.OpTrue),
+				// 0013
+				code.Make(code.OpJump, 17),
+				// 0016
+				code.Make(code.OpFalse),
+				// 0017
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestFloatLiterals(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1.5 + 2",
+			expectedConstants: []interface{}{1.5, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "-1e-9",
+			expectedConstants: []interface{}{1e-9},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpMinus),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
 func TestGlobalLetStatements(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -859,7 +1012,7 @@ func TestBuiltins(t *testing.T) {
 				[]code.Instructions{
 					code.Make(code.OpGetBuiltin, 0),
 					code.Make(code.OpArray, 0),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
 			},
@@ -1014,7 +1167,7 @@ func TestRecursiveFunctions(t *testing.T) {
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpConstant, 0),
 					code.Make(code.OpSub),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
 				1,
@@ -1043,7 +1196,7 @@ func TestRecursiveFunctions(t *testing.T) {
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpConstant, 0),
 					code.Make(code.OpSub),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
 				1,
@@ -1052,7 +1205,7 @@ func TestRecursiveFunctions(t *testing.T) {
 					code.Make(code.OpSetLocal, 0),
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpConstant, 2),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
 			},
@@ -1068,3 +1221,645 @@ func TestRecursiveFunctions(t *testing.T) {
 
 	runCompilerTests(t, tests)
 }
+
+func TestMacros(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+            let unless = macro(cond, cons, alt) {
+                quote(if (!(unquote(cond))) { unquote(cons); } else { unquote(alt); });
+            };
+            unless(1 > 2, 10, 20);
+            `,
+			expectedConstants: []interface{}{1, 2, 10, 20},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpConstant, 1),
+				// 0006
+				code.Make(code.OpGreaterThan),
+				// 0007
+				code.Make(code.OpBang),
+				// 0008
+				code.Make(code.OpJumpNotTruthy, 17),
+				// 0011
+				code.Make(code.OpConstant, 2),
+				// 0014
+				code.Make(code.OpJump, 20),
+				// 0017
+				code.Make(code.OpConstant, 3),
+				// 0020
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestMacroErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			`let f = fn() { let m = macro() { quote(1) }; };`,
+			"1:24: macro literals are only allowed in top-level let statements",
+		},
+		{
+			`quote(1 + 2)`,
+			"1:6: quote can only be used inside a macro",
+		},
+		{
+			`let m = macro() { 1 }; m();`,
+			"1:24: macro expansion failed: we only suppert returning AST-nodes from macros",
+		},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		compiler := New()
+		err := compiler.Compile(program)
+		if err == nil {
+			t.Fatalf("expected compiler error for %q but resulted in none.", tt.input)
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong compiler error: want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestMacroLimits(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		input    string
+		ctx      context.Context
+		limits   object.Limits
+		expected error
+	}{
+		{"let m = macro() { while (true) { } }; m();", context.Background(), object.Limits{MaxSteps: 1000}, object.ErrExecutionLimit},
+		{"let m = macro() { while (true) { } }; m();", cancelled, object.Limits{}, object.ErrCancelled},
+		{"let m = macro() { range(100000); quote(1) }; m();", context.Background(), object.Limits{MaxBytes: 1 << 10}, object.ErrMemoryLimit},
+	}
+
+	for _, tt := range tests {
+		compiler := New()
+		compiler.SetLimits(tt.ctx, tt.limits)
+		err := compiler.Compile(parse(tt.input))
+		if !errors.Is(err, tt.expected) {
+			t.Errorf("wrong compiler error for %q: want=%v, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestLoops(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "while (true) { 10; continue; break; }",
+			expectedConstants: []interface{}{10},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 17),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpPop),
+				// 0008
+				code.Make(code.OpJump, 0),
+				// 0011
+				code.Make(code.OpJump, 17),
+				// 0014
+				code.Make(code.OpJump, 0),
+				// 0017
+				code.Make(code.OpNull),
+				// 0018
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "for (x in [1]) { break; x; }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpArray, 1),
+				// 0006
+				code.Make(code.OpIter),
+				// 0007
+				code.Make(code.OpIterNext, 24),
+				// 0010
+				code.Make(code.OpSetGlobal, 0),
+				// 0013
+				code.Make(code.OpPop),
+				// 0014
+				code.Make(code.OpJump, 24),
+				// 0017
+				code.Make(code.OpGetGlobal, 0),
+				// 0020
+				code.Make(code.OpPop),
+				// 0021
+				code.Make(code.OpJump, 7),
+				// 0024
+				code.Make(code.OpNull),
+				// 0025
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "if (true) { let x = 1; }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 14),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpSetGlobal, 0),
+				// 0010
+				code.Make(code.OpNull),
+				// 0011
+				code.Make(code.OpJump, 15),
+				// 0014
+				code.Make(code.OpNull),
+				// 0015
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let x = 1; x = 2;",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let x = 1; let x = x + 1;",
+			expectedConstants: []interface{}{1, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+		{
+			input: "fn(a) { a -= 1; }",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSub),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn(a) { fn() { a *= 2; } }",
+			expectedConstants: []interface{}{
+				2,
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpMul),
+					code.Make(code.OpSetFree, 0),
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 1, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestAssignExpressionErrors(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"x = 1;", "1:3: undefined variable x"},
+		{"len = 1;", "1:5: cannot assign to builtin len"},
+		{"let f = fn() { f = 1; };", "1:18: cannot assign to function name f"},
+	}
+
+	for _, tt := range tests {
+		compiler := New()
+		err := compiler.Compile(parse(tt.input))
+		if err == nil {
+			t.Fatalf("expected compiler error for %q but resulted in none.", tt.input)
+		}
+		if err.Error() != tt.expectedError {
+			t.Errorf("wrong compiler error: want=%q, got=%q", tt.expectedError, err)
+		}
+	}
+}
+
+func TestIndexAssignExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "[1][0] = 2",
+			expectedConstants: []interface{}{1, 0, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpSetIndex),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "{}[1] += 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpHash, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpDup, 2),
+				code.Make(code.OpIndex),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpSetIndex),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestSourceMap(t *testing.T) {
+	input := `let add = fn(a, b) {
+  a + b
+};
+add(1, [2][0]);`
+
+	compiler := New()
+	err := compiler.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+
+	fn, ok := bytecode.Constants[0].(*object.CompiledFunction)
+	if !ok {
+		t.Fatalf("constant 0 is not CompiledFunction. got=%T", bytecode.Constants[0])
+	}
+	if fn.Name != "add" {
+		t.Errorf("wrong function name. want=%q, got=%q", "add", fn.Name)
+	}
+
+	tests := []struct {
+		sourceMap     code.SourceMap
+		offset        int
+		expectedRange string
+	}{
+		// OpGetLocal 0 -> a
+		{fn.SourceMap, 0, "2:3-2:4"},
+		// OpAdd -> a + b
+		{fn.SourceMap, 4, "2:3-2:8"},
+		// OpReturnValue -> a + b
+		{fn.SourceMap, 5, "2:3-2:8"},
+		// OpClosure -> fn(a, b) { ... }
+		{bytecode.SourceMap, 0, "1:11-3:2"},
+		// OpSetGlobal -> let add = ...
+		{bytecode.SourceMap, 4, "1:1-3:2"},
+		// OpArray -> [2]
+		{bytecode.SourceMap, 16, "4:8-4:11"},
+		// OpIndex -> [2][0]
+		{bytecode.SourceMap, 22, "4:8-4:14"},
+		// OpCall -> add(1, [2][0])
+		{bytecode.SourceMap, 23, "4:1-4:15"},
+	}
+
+	for i, tt := range tests {
+		rng, ok := tt.sourceMap.Lookup(tt.offset)
+		if !ok {
+			t.Fatalf("tests[%d] - no range for offset %d", i, tt.offset)
+		}
+		if rng.String() != tt.expectedRange {
+			t.Errorf("tests[%d] - wrong range. want=%s, got=%s", i, tt.expectedRange, rng)
+		}
+	}
+}
+
+func TestOptimizations(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 + 2 * 3;",
+			expectedConstants: []interface{}{7},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `"mon" + "key";`,
+			expectedConstants: []interface{}{"monkey"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "-(2 - 5) > 1.5 == !false;",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1.5 * 2;",
+			expectedConstants: []interface{}{3.0},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 / 0;",
+			expectedConstants: []interface{}{1, 0},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpDiv),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let a = false && undefined(); 1 > 0 || undefined();",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpFalse),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "if (1 < 2) { 10 } else { 20 }; 3333;",
+			expectedConstants: []interface{}{10, 3333},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let x = if (false) { 10 }; if (true) { };",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpNull),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `1; "unused"; [1, 2]; 2;`,
+			expectedConstants: []interface{}{2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "while (false) { undefined(); } while (true) { break; }",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpNull),
+				// 0001
+				code.Make(code.OpPop),
+				// 0002
+				code.Make(code.OpJump, 8),
+				// 0005
+				code.Make(code.OpJump, 2),
+				// 0008
+				code.Make(code.OpNull),
+				// 0009
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn() { 1; 2 }",
+			expectedConstants: []interface{}{
+				2,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runOptimizedCompilerTests(t, OptimizeBasic, tests)
+}
+
+func runOptimizedCompilerTests(t *testing.T, level int, tests []compilerTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		compiler := New()
+		compiler.SetOptimizationLevel(level)
+		err := compiler.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler err for %q: %s", tt.input, err)
+		}
+		bytecode := compiler.Bytecode()
+		err = testInstructions(tt.expectedInstructions, bytecode.Instructions)
+		if err != nil {
+			t.Fatalf("testInstructions failed for %q: %s", tt.input, err)
+		}
+		err = testConstants(t, tt.expectedConstants, bytecode.Constants)
+		if err != nil {
+			t.Fatalf("testConstants failed for %q: %s", tt.input, err)
+		}
+	}
+}
+
+func TestOptimizationsKeepUnfoldableCode(t *testing.T) {
+	// 这些程序没有可以优化的地方,优化前后的字节码应该完全一样
+	tests := []string{
+		"let a = 1; a + 2;",
+		`"a" == "a";`,
+		"1 == true;",
+		"let a = 1; if (a) { 1 } else { 2 };",
+		"if (false) { let x = 1; }; x;",
+		"while (false) { let y = 1; } y;",
+		"let f = fn(a) { a; 1 }; f(2);",
+		"true && len([]) > 0;",
+	}
+
+	for _, input := range tests {
+		plain := New()
+		err := plain.Compile(parse(input))
+		if err != nil {
+			t.Fatalf("compiler err for %q: %s", input, err)
+		}
+		optimized := New()
+		optimized.SetOptimizationLevel(OptimizeBasic)
+		err = optimized.Compile(parse(input))
+		if err != nil {
+			t.Fatalf("optimized compiler err for %q: %s", input, err)
+		}
+
+		want := plain.Bytecode().Instructions.String()
+		got := optimized.Bytecode().Instructions.String()
+		if want != got {
+			t.Errorf("optimization changed %q.\nwant=\n%s\ngot=\n%s", input, want, got)
+		}
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "let f = fn(x) { f(x) };",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpCurrentClosure),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+		{
+			input: "let f = fn(x) { if (x) { return f(x); } len(x) + f(x) };",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					// 0000
+					code.Make(code.OpGetLocal, 0),
+					// 0002
+					code.Make(code.OpJumpNotTruthy, 15),
+					// 0005
+					code.Make(code.OpCurrentClosure),
+					// 0006
+					code.Make(code.OpGetLocal, 0),
+					// 0008
+					code.Make(code.OpTailCall, 1),
+					// 0010
+					code.Make(code.OpReturnValue),
+					// 0011
+					code.Make(code.OpNull),
+					// 0012
+					code.Make(code.OpJump, 16),
+					// 0015
+					code.Make(code.OpNull),
+					// 0016
+					code.Make(code.OpPop),
+					// 0017
+					code.Make(code.OpGetBuiltin, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpCurrentClosure),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+		{
+			input: "let f = fn(x) { if (x) { f(x) } else { len(x) } };",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					// 0000
+					code.Make(code.OpGetLocal, 0),
+					// 0002
+					code.Make(code.OpJumpNotTruthy, 13),
+					// 0005
+					code.Make(code.OpCurrentClosure),
+					// 0006
+					code.Make(code.OpGetLocal, 0),
+					// 0008
+					code.Make(code.OpTailCall, 1),
+					// 0010
+					code.Make(code.OpJump, 19),
+					// 0013
+					code.Make(code.OpGetBuiltin, 0),
+					// 0015
+					code.Make(code.OpGetLocal, 0),
+					// 0017
+					code.Make(code.OpTailCall, 1),
+					// 0019
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+		{
+			input: "let f = fn() { let x = f(); x };",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpCurrentClosure),
+					code.Make(code.OpCall, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+	}
+
+	runOptimizedCompilerTests(t, OptimizeBasic, tests)
+}
@@ -0,0 +1,152 @@
+package compiler
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"monkey/code"
+	"monkey/object"
+	"sort"
+	"strconv"
+)
+
+// Disassemble 输出字节码的反汇编:先是主程序,然后是常量池里的每个函数。
+// 常量、内置函数和闭包的操作数会被解析出来写在注释里,跳转目标用标签表示
+func Disassemble(w io.Writer, b *Bytecode) error {
+	d := &disassembler{constants: b.Constants, free: map[int]int{}}
+	d.collectFree(b.Instructions)
+	for _, constant := range b.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			d.collectFree(fn.Instructions)
+		}
+	}
+
+	fmt.Fprintf(&d.out, "main:\n")
+	d.instructions(b.Instructions)
+
+	for i, constant := range b.Constants {
+		fn, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(&d.out, "\n%s: locals=%d params=%d free=%d\n",
+			d.functionName(i), fn.NumLocals, fn.NumParameters, d.free[i])
+		d.instructions(fn.Instructions)
+	}
+
+	_, err := w.Write(d.out.Bytes())
+	return err
+}
+
+type disassembler struct {
+	constants []object.Object
+	// free 每个函数常量创建闭包时捕获的自由变量个数
+	free map[int]int
+	out  bytes.Buffer
+}
+
+// collectFree 从 OpClosure 指令里找出每个函数的自由变量个数
+func (d *disassembler) collectFree(ins code.Instructions) {
+	code.Walk(ins, func(offset int, op code.Opcode, operands []int) error {
+		if op == code.OpClosure {
+			d.free[operands[0]] = operands[1]
+		}
+		return nil
+	})
+}
+
+func (d *disassembler) instructions(ins code.Instructions) {
+	labels := jumpLabels(ins)
+
+	err := code.Walk(ins, func(offset int, op code.Opcode, operands []int) error {
+		if label, ok := labels[offset]; ok {
+			fmt.Fprintf(&d.out, "%s:\n", label)
+		}
+		def, _ := code.Lookup(byte(op))
+		fmt.Fprintf(&d.out, "  %04d %s", offset, def.Name)
+		for i, operand := range operands {
+			if i == 0 && code.IsJump(op) {
+				fmt.Fprintf(&d.out, " %s", labels[operand])
+			} else {
+				fmt.Fprintf(&d.out, " %d", operand)
+			}
+		}
+		if comment := d.comment(op, operands); comment != "" {
+			fmt.Fprintf(&d.out, "  ; %s", comment)
+		}
+		fmt.Fprintf(&d.out, "\n")
+		return nil
+	})
+
+	if decodeErr, ok := err.(*code.DecodeError); ok {
+		fmt.Fprintf(&d.out, "  %04d ERROR: cannot decode opcode %d\n", decodeErr.Offset, ins[decodeErr.Offset])
+		return
+	}
+	if label, ok := labels[len(ins)]; ok {
+		fmt.Fprintf(&d.out, "%s:\n", label)
+	}
+}
+
+// comment 把操作数指向的常量或内置函数写出来
+func (d *disassembler) comment(op code.Opcode, operands []int) string {
+	switch op {
+	case code.OpConstant:
+		return d.constant(operands[0])
+	case code.OpClosure:
+		return d.functionName(operands[0])
+	case code.OpAddLocalConstant, code.OpSubLocalConstant:
+		return d.constant(operands[1])
+	case code.OpGetBuiltin:
+		if operands[0] < len(object.Builtins) {
+			return object.Builtins[operands[0]].Name
+		}
+	}
+	return ""
+}
+
+func (d *disassembler) constant(index int) string {
+	if index >= len(d.constants) {
+		return "<invalid constant>"
+	}
+	switch constant := d.constants[index].(type) {
+	case *object.String:
+		return strconv.Quote(constant.Value)
+	case *object.CompiledFunction:
+		return d.functionName(index)
+	default:
+		return constant.Inspect()
+	}
+}
+
+// functionName 函数以常量下标编号,有名字的函数再带上名字
+func (d *disassembler) functionName(index int) string {
+	if index < len(d.constants) {
+		if fn, ok := d.constants[index].(*object.CompiledFunction); ok && fn.Name != "" {
+			return fmt.Sprintf("fn%d <%s>", index, fn.Name)
+		}
+	}
+	return fmt.Sprintf("fn%d", index)
+}
+
+// jumpLabels 给每个跳转目标按偏移量顺序编号
+func jumpLabels(ins code.Instructions) map[int]string {
+	targets := map[int]bool{}
+	code.Walk(ins, func(offset int, op code.Opcode, operands []int) error {
+		if code.IsJump(op) {
+			targets[operands[0]] = true
+		}
+		return nil
+	})
+
+	sorted := make([]int, 0, len(targets))
+	for target := range targets {
+		sorted = append(sorted, target)
+	}
+	sort.Ints(sorted)
+
+	labels := make(map[int]string, len(sorted))
+	for i, target := range sorted {
+		labels[target] = fmt.Sprintf("L%d", i+1)
+	}
+	return labels
+}
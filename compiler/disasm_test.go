@@ -0,0 +1,96 @@
+package compiler
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDisassemble(t *testing.T) {
+	input := `let add = fn(a, b) { a + b };
+let adder = fn(x) { fn(y) { add(x, y) } };
+if (len("ab") > 1) { adder(1)(2.5) } else { 0 };`
+
+	expected := `main:
+  0000 OpClosure 0 0  ; fn0 <add>
+  0004 OpSetGlobal 0
+  0007 OpClosure 2 0  ; fn2 <adder>
+  0011 OpSetGlobal 1
+  0014 OpGetBuiltin 0  ; len
+  0016 OpConstant 3  ; "ab"
+  0019 OpCall 1
+  0021 OpConstant 4  ; 1
+  0024 OpGreaterThan
+  0025 OpJumpNotTruthy L1
+  0028 OpGetGlobal 1
+  0031 OpConstant 5  ; 1
+  0034 OpCall 1
+  0036 OpConstant 6  ; 2.5
+  0039 OpCall 1
+  0041 OpJump L2
+L1:
+  0044 OpConstant 7  ; 0
+L2:
+  0047 OpPop
+
+fn0 <add>: locals=2 params=2 free=0
+  0000 OpGetLocal 0
+  0002 OpGetLocal 1
+  0004 OpAdd
+  0005 OpReturnValue
+
+fn1: locals=1 params=1 free=1
+  0000 OpGetGlobal 0
+  0003 OpGetFree 0
+  0005 OpGetLocal 0
+  0007 OpTailCall 2
+  0009 OpReturnValue
+
+fn2 <adder>: locals=1 params=1 free=0
+  0000 OpGetLocal 0
+  0002 OpClosure 1 1  ; fn1
+  0006 OpReturnValue
+`
+
+	var out bytes.Buffer
+	if err := Disassemble(&out, compileForSerialize(t, input, OptimizeNone)); err != nil {
+		t.Fatalf("disassemble error: %s", err)
+	}
+	if out.String() != expected {
+		t.Errorf("wrong disassembly.\nwant=\n%s\ngot=\n%s", expected, out.String())
+	}
+}
+
+func TestDisassembleLoopLabels(t *testing.T) {
+	input := `for (x in [1]) { if (x) { break } }`
+
+	expected := `main:
+  0000 OpConstant 0  ; 1
+  0003 OpArrey 1
+  0006 OpIter
+L1:
+  0007 OpIterNext L4
+  0010 OpSetGlobal 0
+  0013 OpGetGlobal 0
+  0016 OpJumpNotTruthy L2
+  0019 OpPop
+  0020 OpJump L4
+  0023 OpNull
+  0024 OpJump L3
+L2:
+  0027 OpNull
+L3:
+  0028 OpPop
+  0029 OpJump L1
+L4:
+  0032 OpNull
+  0033 OpPop
+`
+
+	var out bytes.Buffer
+	if err := Disassemble(&out, compileForSerialize(t, input, OptimizeNone)); err != nil {
+		t.Fatalf("disassemble error: %s", err)
+	}
+	if out.String() != expected {
+		t.Errorf("wrong disassembly.\nwant=\n%s\ngot=\n%s", expected, out.String())
+	}
+}
@@ -0,0 +1,50 @@
+package compiler
+
+import (
+	"context"
+	"fmt"
+	"monkey/ast"
+	"monkey/evaluator"
+	"monkey/object"
+)
+
+// SetMacroEnv 让多次编译共享同一个宏环境(比如REPL里前面定义的宏在后面的输入中也能使用)
+func (c *Compiler) SetMacroEnv(env *object.Environment) {
+	c.macroEnv = env
+}
+
+// SetLimits 限制展开宏时求值的步数和内存,ctx 被取消时停止展开。
+// 超出限制时 Compile 返回的错误可以用 errors.Is 和 object.ErrExecutionLimit 等比较
+func (c *Compiler) SetLimits(ctx context.Context, limits object.Limits) {
+	limits.Done = ctx.Done()
+	c.macroLimits = &limits
+}
+
+// expandMacros 在编译之前运行:
+// 复用求值器的 DefineMacros/ExpandMacros,把宏定义从程序中移除并展开所有宏调用,
+// 这样编译器看到的只剩普通的AST节点
+func (c *Compiler) expandMacros(program *ast.Program) (*ast.Program, error) {
+	evaluator.DefineMacros(program, c.macroEnv)
+
+	for i, s := range program.Statements {
+		expanded, err := c.expandStatement(s)
+		if err != nil {
+			return nil, err
+		}
+		program.Statements[i] = expanded
+	}
+	return program, nil
+}
+
+// expandStatement 逐条语句展开,这样出错时能报告是哪一条语句里的宏调用
+func (c *Compiler) expandStatement(s ast.Statement) (ast.Statement, error) {
+	node, err := evaluator.ExpandMacrosWithLimits(s, c.macroEnv, c.macroLimits)
+	if err != nil {
+		return nil, fmt.Errorf("%s: macro expansion failed: %w", s.Pos(), err)
+	}
+	expanded, ok := node.(ast.Statement)
+	if !ok {
+		return nil, fmt.Errorf("%s: macro expansion returned %T, want a statement", s.Pos(), node)
+	}
+	return expanded, nil
+}
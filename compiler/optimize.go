@@ -0,0 +1,326 @@
+package compiler
+
+import (
+	"math"
+	"monkey/ast"
+	"monkey/code"
+	"monkey/token"
+	"strconv"
+)
+
+// 优化级别
+const (
+	// OptimizeNone 不做优化,指令和源码一一对应。尾调用改成 OpTailCall 不算优化,总是会做
+	OptimizeNone = 0
+	// OptimizeBasic 常量折叠、删除不可达的分支和结果没有用到的纯表达式
+	OptimizeBasic = 1
+	// OptimizePeephole 在 OptimizeBasic 的基础上再用 code.Peephole 合并超级指令
+	OptimizePeephole = 2
+)
+
+// SetOptimizationLevel 设置优化级别,默认是 OptimizeNone
+func (c *Compiler) SetOptimizationLevel(level int) {
+	c.optimizationLevel = level
+}
+
+// markTailCalls 把尾位置上的 OpCall 原地改成宽度相同的 OpTailCall。
+// 尾位置是指调用以后紧接着 OpReturnValue,或者经过若干个 OpJump 到达 OpReturnValue。
+// 后面的 OpReturnValue 保留,尾调用内置函数时还会执行到它
+func markTailCalls(ins code.Instructions) {
+	ops := map[int]code.Opcode{}
+	jumps := map[int]int{}
+	var calls []int
+	code.Walk(ins, func(offset int, op code.Opcode, operands []int) error {
+		ops[offset] = op
+		switch op {
+		case code.OpJump:
+			jumps[offset] = operands[0]
+		case code.OpCall:
+			calls = append(calls, offset)
+		}
+		return nil
+	})
+
+	returnsImmediately := func(offset int) bool {
+		// 最多跟随 len(jumps) 次跳转,避免死循环
+		for i := 0; i <= len(jumps); i++ {
+			target, ok := jumps[offset]
+			if !ok {
+				break
+			}
+			offset = target
+		}
+		return ops[offset] == code.OpReturnValue
+	}
+
+	for _, offset := range calls {
+		if returnsImmediately(offset + 2) {
+			ins[offset] = byte(code.OpTailCall)
+		}
+	}
+}
+
+// foldConstants 自底向上把操作数都是字面量的表达式替换成计算结果。
+// 折叠的结果必须和虚拟机运行时算出来的一样,会在运行时出错的表达式(比如除以零)保持原样
+func foldConstants(node ast.Node) ast.Node {
+	return ast.Modify(node, func(node ast.Node) ast.Node {
+		var folded ast.Expression
+		switch node := node.(type) {
+		case *ast.PrefixExpression:
+			folded = foldPrefixExpression(node)
+		case *ast.InfixExpression:
+			folded = foldInfixExpression(node)
+		}
+		if folded == nil {
+			return node
+		}
+		return folded
+	})
+}
+
+func foldPrefixExpression(node *ast.PrefixExpression) ast.Expression {
+	switch node.Operator {
+	case "!":
+		if truthy, ok := constantTruthiness(node.Right); ok {
+			return newBooleanLiteral(node.Token, !truthy)
+		}
+	case "-":
+		switch right := node.Right.(type) {
+		case *ast.IntegerLiteral:
+			return newIntegerLiteral(node.Token, -right.Value)
+		case *ast.FloatLiteral:
+			return newFloatLiteral(node.Token, -right.Value)
+		}
+	}
+	return nil
+}
+
+func foldInfixExpression(node *ast.InfixExpression) ast.Expression {
+	tok := node.Token
+	tok.Pos = ast.Range(node).Start
+
+	if node.Operator == "&&" || node.Operator == "||" {
+		return foldLogicalExpression(tok, node)
+	}
+
+	switch left := node.Left.(type) {
+	case *ast.IntegerLiteral:
+		if right, ok := node.Right.(*ast.IntegerLiteral); ok {
+			return foldIntegerInfix(tok, node.Operator, left.Value, right.Value)
+		}
+	case *ast.StringLiteral:
+		if right, ok := node.Right.(*ast.StringLiteral); ok && node.Operator == "+" {
+			return newStringLiteral(tok, left.Value+right.Value)
+		}
+	case *ast.Boolean:
+		if right, ok := node.Right.(*ast.Boolean); ok {
+			switch node.Operator {
+			case "==":
+				return newBooleanLiteral(tok, left.Value == right.Value)
+			case "!=":
+				return newBooleanLiteral(tok, left.Value != right.Value)
+			}
+		}
+	}
+
+	leftValue, leftOk := constantNumber(node.Left)
+	rightValue, rightOk := constantNumber(node.Right)
+	// 两边都是整数时上面已经处理过了,比如整数除以零要留到运行时报错
+	if leftOk && rightOk && (isFloatLiteral(node.Left) || isFloatLiteral(node.Right)) {
+		return foldFloatInfix(tok, node.Operator, leftValue, rightValue)
+	}
+	return nil
+}
+
+// foldLogicalExpression 左边是常量时就能确定是否短路;不短路时右边也必须是常量
+func foldLogicalExpression(tok token.Token, node *ast.InfixExpression) ast.Expression {
+	left, ok := constantTruthiness(node.Left)
+	if !ok {
+		return nil
+	}
+	if node.Operator == "&&" && !left {
+		return newBooleanLiteral(tok, false)
+	}
+	if node.Operator == "||" && left {
+		return newBooleanLiteral(tok, true)
+	}
+	right, ok := constantTruthiness(node.Right)
+	if !ok {
+		return nil
+	}
+	return newBooleanLiteral(tok, right)
+}
+
+func foldIntegerInfix(tok token.Token, operator string, left, right int64) ast.Expression {
+	switch operator {
+	case "+":
+		return newIntegerLiteral(tok, left+right)
+	case "-":
+		return newIntegerLiteral(tok, left-right)
+	case "*":
+		return newIntegerLiteral(tok, left*right)
+	case "/":
+		if right != 0 {
+			return newIntegerLiteral(tok, left/right)
+		}
+	case "%":
+		if right != 0 {
+			return newIntegerLiteral(tok, left%right)
+		}
+	case "<":
+		return newBooleanLiteral(tok, left < right)
+	case "<=":
+		return newBooleanLiteral(tok, left <= right)
+	case ">":
+		return newBooleanLiteral(tok, left > right)
+	case ">=":
+		return newBooleanLiteral(tok, left >= right)
+	case "==":
+		return newBooleanLiteral(tok, left == right)
+	case "!=":
+		return newBooleanLiteral(tok, left != right)
+	}
+	return nil
+}
+
+// foldFloatInfix 至少有一边是浮点数,和虚拟机一样把整数提升为浮点数
+func foldFloatInfix(tok token.Token, operator string, left, right float64) ast.Expression {
+	switch operator {
+	case "+":
+		return newFloatLiteral(tok, left+right)
+	case "-":
+		return newFloatLiteral(tok, left-right)
+	case "*":
+		return newFloatLiteral(tok, left*right)
+	case "/":
+		return newFloatLiteral(tok, left/right)
+	case "%":
+		return newFloatLiteral(tok, math.Mod(left, right))
+	case "<":
+		return newBooleanLiteral(tok, left < right)
+	case "<=":
+		return newBooleanLiteral(tok, left <= right)
+	case ">":
+		return newBooleanLiteral(tok, left > right)
+	case ">=":
+		return newBooleanLiteral(tok, left >= right)
+	case "==":
+		return newBooleanLiteral(tok, left == right)
+	case "!=":
+		return newBooleanLiteral(tok, left != right)
+	}
+	return nil
+}
+
+// constantNumber 数字字面量的值
+func constantNumber(node ast.Expression) (float64, bool) {
+	switch node := node.(type) {
+	case *ast.FloatLiteral:
+		return node.Value, true
+	case *ast.IntegerLiteral:
+		return float64(node.Value), true
+	}
+	return 0, false
+}
+
+func isFloatLiteral(node ast.Expression) bool {
+	_, ok := node.(*ast.FloatLiteral)
+	return ok
+}
+
+// constantTruthiness 字面量在条件判断里的真假,规则和虚拟机的 isTruthy 一致
+func constantTruthiness(node ast.Expression) (bool, bool) {
+	switch node := node.(type) {
+	case *ast.Boolean:
+		return node.Value, true
+	case *ast.IntegerLiteral, *ast.FloatLiteral, *ast.StringLiteral:
+		return true, true
+	}
+	return false, false
+}
+
+// isPureExpression 求值时不会出错也没有副作用的表达式
+func isPureExpression(node ast.Expression) bool {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral, *ast.FloatLiteral, *ast.StringLiteral, *ast.Boolean:
+		return true
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			if !isPureExpression(el) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// isUnusedPureStatement 不是最后一条语句的纯表达式语句,结果会被直接弹出,可以不编译。
+// 最后一条语句的值可能是函数或者 if 的返回值,要保留
+func isUnusedPureStatement(statements []ast.Statement, i int) bool {
+	if i == len(statements)-1 {
+		return false
+	}
+	stmt, ok := statements[i].(*ast.ExpressionStatement)
+	return ok && isPureExpression(stmt.Expression)
+}
+
+// declaresNames 代码块里是否定义了变量。
+// if 和 while 没有自己的作用域,删掉这样的代码块会让后面对这些变量的引用无法编译
+func declaresNames(block *ast.BlockStatement) bool {
+	if block == nil {
+		return false
+	}
+	found := false
+	ast.Modify(block, func(node ast.Node) ast.Node {
+		switch node.(type) {
+		case *ast.LetStatement, *ast.ForStatement:
+			found = true
+		}
+		return node
+	})
+	return found
+}
+
+// constantBranch 条件是常量的 if 表达式只会执行其中一个分支,返回这个分支(可能是 nil)
+func constantBranch(node *ast.IfExpression) (*ast.BlockStatement, bool) {
+	truthy, ok := constantTruthiness(node.Condition)
+	if !ok {
+		return nil, false
+	}
+	taken, dropped := node.Consequence, node.Alternative
+	if !truthy {
+		taken, dropped = node.Alternative, node.Consequence
+	}
+	if declaresNames(dropped) {
+		return nil, false
+	}
+	return taken, true
+}
+
+func newIntegerLiteral(tok token.Token, value int64) *ast.IntegerLiteral {
+	tok.Type = token.INT
+	tok.Literal = strconv.FormatInt(value, 10)
+	return &ast.IntegerLiteral{Token: tok, Value: value}
+}
+
+func newFloatLiteral(tok token.Token, value float64) *ast.FloatLiteral {
+	tok.Type = token.FLOAT
+	tok.Literal = strconv.FormatFloat(value, 'g', -1, 64)
+	return &ast.FloatLiteral{Token: tok, Value: value}
+}
+
+func newStringLiteral(tok token.Token, value string) *ast.StringLiteral {
+	tok.Type = token.STRING
+	tok.Literal = value
+	return &ast.StringLiteral{Token: tok, Value: value}
+}
+
+func newBooleanLiteral(tok token.Token, value bool) *ast.Boolean {
+	tok.Type = token.FALSE
+	if value {
+		tok.Type = token.TRUE
+	}
+	tok.Literal = strconv.FormatBool(value)
+	return &ast.Boolean{Token: tok, Value: value}
+}
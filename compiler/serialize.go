@@ -0,0 +1,533 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"monkey/code"
+	"monkey/object"
+	"monkey/token"
+)
+
+// 字节码文件的格式:
+//
+//	magic "MKC\0"
+//	格式版本      uint16
+//	opcode 版本   uint32 (code.Version)
+//	内置函数名    uint32 个数 + 字符串
+//	主程序指令    code.Instructions
+//	主程序源码映射 code.SourceMap
+//	全局变量个数   uint32
+//	常量池        uint32 个数 + 带类型标记的常量
+//
+// 所有整数都是大端序,字符串和字节切片前面是 uint32 的长度
+const (
+	bytecodeMagic  = "MKC\x00"
+	bytecodeFormat = 2
+)
+
+// 常量池里常量的类型标记
+const (
+	constantInteger byte = iota + 1
+	constantFloat
+	constantString
+	constantFunction
+)
+
+// IsBytecode 数据是否以字节码文件的 magic 开头
+func IsBytecode(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(bytecodeMagic))
+}
+
+// Serialize 把字节码写成二进制格式
+func (b *Bytecode) Serialize(w io.Writer) error {
+	e := &encoder{}
+	e.buf.WriteString(bytecodeMagic)
+	e.uint16(bytecodeFormat)
+	e.uint32(code.Version())
+
+	e.uint32(uint32(len(object.Builtins)))
+	for _, builtin := range object.Builtins {
+		e.string(builtin.Name)
+	}
+
+	e.bytes(b.Instructions)
+	e.sourceMap(b.SourceMap)
+	e.uint32(uint32(b.NumGlobals))
+
+	e.uint32(uint32(len(b.Constants)))
+	for _, constant := range b.Constants {
+		if err := e.constant(constant); err != nil {
+			return err
+		}
+	}
+
+	_, err := w.Write(e.buf.Bytes())
+	return err
+}
+
+// Deserialize 读取 Serialize 写出的字节码并校验:
+// magic、格式版本、opcode 版本和内置函数都要和当前程序一致,
+// 指令里引用的常量、跳转目标、局部变量、自由变量和全局变量也都必须合法,并且不会从空栈上出栈
+func Deserialize(r io.Reader) (*Bytecode, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if !IsBytecode(data) {
+		return nil, fmt.Errorf("not a bytecode file")
+	}
+
+	d := &decoder{data: data, offset: len(bytecodeMagic)}
+	if format := d.uint16(); d.err == nil && format != bytecodeFormat {
+		return nil, fmt.Errorf("unsupported bytecode format %d, want %d", format, bytecodeFormat)
+	}
+	if version := d.uint32(); d.err == nil && version != code.Version() {
+		return nil, fmt.Errorf("bytecode was built for a different opcode set (version %08x, want %08x)", version, code.Version())
+	}
+
+	numBuiltins := d.length()
+	for i := 0; i < numBuiltins && d.err == nil; i++ {
+		name := d.string()
+		if d.err == nil && (i >= len(object.Builtins) || object.Builtins[i].Name != name) {
+			return nil, fmt.Errorf("bytecode was built with a different set of builtins (%s at %d)", name, i)
+		}
+	}
+
+	bytecode := &Bytecode{}
+	bytecode.Instructions = d.bytes()
+	bytecode.SourceMap = d.sourceMap()
+	bytecode.NumGlobals = int(d.uint32())
+
+	numConstants := d.length()
+	bytecode.Constants = make([]object.Object, 0, numConstants)
+	for i := 0; i < numConstants && d.err == nil; i++ {
+		bytecode.Constants = append(bytecode.Constants, d.constant())
+	}
+
+	if d.err != nil {
+		return nil, d.err
+	}
+	if d.offset != len(d.data) {
+		return nil, fmt.Errorf("unexpected %d bytes after constants", len(d.data)-d.offset)
+	}
+
+	if bytecode.NumGlobals > math.MaxUint16+1 {
+		return nil, fmt.Errorf("too many globals: %d", bytecode.NumGlobals)
+	}
+	numFree, err := closureFreeCounts(bytecode)
+	if err != nil {
+		return nil, err
+	}
+
+	main := scope{numGlobals: bytecode.NumGlobals, constants: bytecode.Constants}
+	err = validateInstructions(bytecode.Instructions, bytecode.SourceMap, main)
+	if err != nil {
+		return nil, fmt.Errorf("main program: %s", err)
+	}
+	for i, constant := range bytecode.Constants {
+		fn, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+		if fn.NumParameters > fn.NumLocals || fn.NumLocals > math.MaxUint8+1 {
+			return nil, fmt.Errorf("constant %d: invalid locals %d (parameters %d)", i, fn.NumLocals, fn.NumParameters)
+		}
+		s := main
+		s.numLocals, s.numFree = fn.NumLocals, numFree[i]
+		err := validateInstructions(fn.Instructions, fn.SourceMap, s)
+		if err != nil {
+			return nil, fmt.Errorf("constant %d: %s", i, err)
+		}
+	}
+	return bytecode, nil
+}
+
+// scope 校验一段指令时它能访问的变量和常量
+type scope struct {
+	numLocals  int
+	numFree    int
+	numGlobals int
+	constants  []object.Object
+}
+
+// closureFreeCounts 从所有 OpClosure 指令里找出每个函数常量的自由变量个数。
+// 同一个函数在不同的地方用不同的个数创建闭包时,它的 OpGetFree 没法校验,直接拒绝
+func closureFreeCounts(bytecode *Bytecode) (map[int]int, error) {
+	numFree := map[int]int{}
+	record := func(ins code.Instructions) error {
+		err := code.Walk(ins, func(offset int, op code.Opcode, operands []int) error {
+			if op == code.OpClosure {
+				if n, ok := numFree[operands[0]]; ok && n != operands[1] {
+					return fmt.Errorf("offset %d: closure over constant %d with %d free variables, elsewhere %d", offset, operands[0], operands[1], n)
+				}
+				numFree[operands[0]] = operands[1]
+			}
+			return nil
+		})
+		if _, ok := err.(*code.DecodeError); ok {
+			// 解码的错误留给 validateInstructions 报告
+			return nil
+		}
+		return err
+	}
+
+	if err := record(bytecode.Instructions); err != nil {
+		return nil, fmt.Errorf("main program: %s", err)
+	}
+	for i, constant := range bytecode.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			if err := record(fn.Instructions); err != nil {
+				return nil, fmt.Errorf("constant %d: %s", i, err)
+			}
+		}
+	}
+	return numFree, nil
+}
+
+// validateInstructions 检查每条指令都能完整解码,操作数指向合法的位置,并且栈不会下溢
+func validateInstructions(ins code.Instructions, sourceMap code.SourceMap, s scope) error {
+	constants, numLocals := s.constants, s.numLocals
+	decoded := map[int]*decodedInstruction{}
+	jumps := map[int]int{}
+
+	prev := -1
+	err := code.Walk(ins, func(offset int, op code.Opcode, operands []int) error {
+		decoded[offset] = &decodedInstruction{op: op, operands: operands, next: len(ins)}
+		if prev >= 0 {
+			decoded[prev].next = offset
+		}
+		prev = offset
+
+		switch op {
+		case code.OpConstant:
+			if operands[0] >= len(constants) {
+				return fmt.Errorf("offset %d: constant %d out of range", offset, operands[0])
+			}
+		case code.OpClosure:
+			if operands[0] >= len(constants) {
+				return fmt.Errorf("offset %d: constant %d out of range", offset, operands[0])
+			}
+			if _, ok := constants[operands[0]].(*object.CompiledFunction); !ok {
+				return fmt.Errorf("offset %d: constant %d is not a function", offset, operands[0])
+			}
+		case code.OpGetBuiltin:
+			if operands[0] >= len(object.Builtins) {
+				return fmt.Errorf("offset %d: builtin %d out of range", offset, operands[0])
+			}
+		case code.OpGetFree, code.OpSetFree, code.OpCaptureFree:
+			if operands[0] >= s.numFree {
+				return fmt.Errorf("offset %d: free variable %d out of range", offset, operands[0])
+			}
+		case code.OpGetGlobal, code.OpSetGlobal:
+			if operands[0] >= s.numGlobals {
+				return fmt.Errorf("offset %d: global %d out of range", offset, operands[0])
+			}
+		case code.OpGetLocal, code.OpSetLocal, code.OpCaptureLocal:
+			if operands[0] >= numLocals {
+				return fmt.Errorf("offset %d: local %d out of range", offset, operands[0])
+			}
+		case code.OpAddLocalConstant, code.OpSubLocalConstant:
+			if operands[0] >= numLocals {
+				return fmt.Errorf("offset %d: local %d out of range", offset, operands[0])
+			}
+			if operands[1] >= len(constants) {
+				return fmt.Errorf("offset %d: constant %d out of range", offset, operands[1])
+			}
+		}
+		if code.IsJump(op) {
+			jumps[offset] = operands[0]
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for offset, target := range jumps {
+		if _, ok := decoded[target]; target != len(ins) && !ok {
+			return fmt.Errorf("offset %d: invalid jump target %d", offset, target)
+		}
+	}
+
+	last := -1
+	for _, entry := range sourceMap {
+		if entry.Offset <= last || entry.Offset >= len(ins) {
+			return fmt.Errorf("invalid source map offset %d", entry.Offset)
+		}
+		last = entry.Offset
+	}
+	return validateStack(ins, decoded)
+}
+
+// decodedInstruction validateInstructions 解码出的一条指令,next 是下一条指令的偏移量
+type decodedInstruction struct {
+	op       code.Opcode
+	operands []int
+	next     int
+}
+
+// validateStack 沿着所有可能的执行路径计算每条指令开始时栈上至少有几个值,
+// 有指令要出栈的值比这更多时返回错误。几条路径汇合时按最少的算
+func validateStack(ins code.Instructions, decoded map[int]*decodedInstruction) error {
+	depths := map[int]int{}
+	pending := []int{0}
+	visit := func(offset, depth int) {
+		if offset >= len(ins) {
+			return
+		}
+		if d, ok := depths[offset]; !ok || depth < d {
+			depths[offset] = depth
+			pending = append(pending, offset)
+		}
+	}
+	if len(ins) == 0 {
+		return nil
+	}
+	depths[0] = 0
+
+	for len(pending) > 0 {
+		offset := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		depth := depths[offset]
+
+		op, operands, next := decoded[offset].op, decoded[offset].operands, decoded[offset].next
+
+		pop, push := stackEffect(op, operands)
+		if depth < pop {
+			def, _ := code.Lookup(byte(op))
+			return fmt.Errorf("offset %d: %s pops %d values from a stack of %d", offset, def.Name, pop, depth)
+		}
+		depth += push - pop
+
+		switch op {
+		case code.OpReturnValue, code.OpReturn:
+			continue
+		case code.OpJump:
+			visit(operands[0], depth)
+			continue
+		case code.OpIterNext:
+			// 迭代结束时弹出迭代器再跳转,否则压入下一个元素
+			visit(operands[0], depth-1)
+			depth++
+		default:
+			if code.IsJump(op) {
+				visit(operands[0], depth)
+			}
+		}
+		visit(next, depth)
+	}
+	return nil
+}
+
+// stackEffect 指令从栈上弹出和压入的值的个数,和虚拟机里的实现对应
+func stackEffect(op code.Opcode, operands []int) (pop, push int) {
+	switch op {
+	case code.OpConstant, code.OpTrue, code.OpFalse, code.OpNull,
+		code.OpGetGlobal, code.OpGetLocal, code.OpGetBuiltin, code.OpGetFree,
+		code.OpCaptureLocal, code.OpCaptureFree, code.OpCurrentClosure,
+		code.OpAddLocalConstant, code.OpSubLocalConstant:
+		return 0, 1
+	case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod,
+		code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpGreaterEqual, code.OpIndex:
+		return 2, 1
+	case code.OpMinus, code.OpBang, code.OpIter:
+		return 1, 1
+	case code.OpPop, code.OpJumpNotTruthy, code.OpSetGlobal, code.OpSetLocal, code.OpSetFree,
+		code.OpReturnValue:
+		return 1, 0
+	case code.OpJumpNotEqual, code.OpJumpEqual, code.OpJumpNotGreater, code.OpJumpNotGreaterEqual:
+		return 2, 0
+	case code.OpSetIndex:
+		return 3, 1
+	case code.OpArray, code.OpHash:
+		return operands[0], 1
+	case code.OpClosure:
+		return operands[1], 1
+	case code.OpCall, code.OpTailCall:
+		return operands[0] + 1, 1
+	case code.OpDup:
+		return operands[0], 2 * operands[0]
+	case code.OpIterNext:
+		// 只是查看栈顶的迭代器,压入的元素在 validateStack 里按分支处理
+		return 1, 1
+	default:
+		return 0, 0
+	}
+}
+
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *encoder) uint16(v uint16) {
+	binary.Write(&e.buf, binary.BigEndian, v)
+}
+
+func (e *encoder) uint32(v uint32) {
+	binary.Write(&e.buf, binary.BigEndian, v)
+}
+
+func (e *encoder) uint64(v uint64) {
+	binary.Write(&e.buf, binary.BigEndian, v)
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uint32(uint32(len(b)))
+	e.buf.Write(b)
+}
+
+func (e *encoder) string(s string) {
+	e.bytes([]byte(s))
+}
+
+// sourceMap 一个函数里的位置都在同一个文件中,文件名只写一次
+func (e *encoder) sourceMap(m code.SourceMap) {
+	filename := ""
+	if len(m) > 0 {
+		filename = m[0].Range.Start.Filename
+	}
+	e.string(filename)
+	e.uint32(uint32(len(m)))
+	for _, entry := range m {
+		e.uint32(uint32(entry.Offset))
+		e.uint32(uint32(entry.Range.Start.Line))
+		e.uint32(uint32(entry.Range.Start.Column))
+		e.uint32(uint32(entry.Range.End.Line))
+		e.uint32(uint32(entry.Range.End.Column))
+	}
+}
+
+func (e *encoder) constant(obj object.Object) error {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		e.buf.WriteByte(constantInteger)
+		e.uint64(uint64(obj.Value))
+	case *object.Float:
+		e.buf.WriteByte(constantFloat)
+		e.uint64(math.Float64bits(obj.Value))
+	case *object.String:
+		e.buf.WriteByte(constantString)
+		e.string(obj.Value)
+	case *object.CompiledFunction:
+		e.buf.WriteByte(constantFunction)
+		e.uint32(uint32(obj.NumLocals))
+		e.uint32(uint32(obj.NumParameters))
+		e.string(obj.Name)
+		e.bytes(obj.Instructions)
+		e.sourceMap(obj.SourceMap)
+	default:
+		return fmt.Errorf("cannot serialize constant of type %s", obj.Type())
+	}
+	return nil
+}
+
+// decoder 读到数据末尾或者遇到非法数据时记下第一个错误,之后的读取都返回零值
+type decoder struct {
+	data   []byte
+	offset int
+	err    error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.data)-d.offset {
+		d.err = fmt.Errorf("unexpected end of bytecode at offset %d", d.offset)
+		return nil
+	}
+	b := d.data[d.offset : d.offset+n]
+	d.offset += n
+	return b
+}
+
+func (d *decoder) byte() byte {
+	b := d.next(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *decoder) uint16() uint16 {
+	b := d.next(2)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+func (d *decoder) uint32() uint32 {
+	b := d.next(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (d *decoder) uint64() uint64 {
+	b := d.next(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+// length 读一个长度,长度不可能超过剩下的数据
+func (d *decoder) length() int {
+	n := int(d.uint32())
+	if d.err == nil && n > len(d.data)-d.offset {
+		d.err = fmt.Errorf("invalid length %d at offset %d", n, d.offset-4)
+		return 0
+	}
+	return n
+}
+
+func (d *decoder) bytes() []byte {
+	b := d.next(d.length())
+	return append([]byte{}, b...)
+}
+
+func (d *decoder) string() string {
+	return string(d.next(d.length()))
+}
+
+func (d *decoder) sourceMap() code.SourceMap {
+	filename := d.string()
+	n := d.length()
+	m := code.SourceMap{}
+	for i := 0; i < n && d.err == nil; i++ {
+		entry := code.SourceMapEntry{Offset: int(d.uint32())}
+		entry.Range.Start = token.Position{Filename: filename, Line: int(d.uint32()), Column: int(d.uint32())}
+		entry.Range.End = token.Position{Filename: filename, Line: int(d.uint32()), Column: int(d.uint32())}
+		m = append(m, entry)
+	}
+	return m
+}
+
+func (d *decoder) constant() object.Object {
+	switch tag := d.byte(); tag {
+	case constantInteger:
+		return &object.Integer{Value: int64(d.uint64())}
+	case constantFloat:
+		return &object.Float{Value: math.Float64frombits(d.uint64())}
+	case constantString:
+		return &object.String{Value: d.string()}
+	case constantFunction:
+		fn := &object.CompiledFunction{}
+		fn.NumLocals = int(d.uint32())
+		fn.NumParameters = int(d.uint32())
+		fn.Name = d.string()
+		fn.Instructions = d.bytes()
+		fn.SourceMap = d.sourceMap()
+		return fn
+	default:
+		if d.err == nil {
+			d.err = fmt.Errorf("unknown constant type %d at offset %d", tag, d.offset-1)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,179 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"monkey/code"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func compileForSerialize(t *testing.T, input string, level int) *Bytecode {
+	l := lexer.NewWithFilename("test.mk", input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	comp := New()
+	comp.SetOptimizationLevel(level)
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return comp.Bytecode()
+}
+
+func TestSerializeRoundTrip(t *testing.T) {
+	input := `
+let add = fn(a, b) { let c = a + b; c };
+let adder = fn(x) { fn(y) { x + y } };
+let s = "hello" + " world";
+let f = 3.25 * 2.0;
+let i = 0;
+while (i < 10) { i += 1; }
+for (x in [1, 2, 3]) { puts(add(x, adder(1)(-5))); }
+let dec = fn(n) { if (n > 0) { n - 1 } else { n } };
+`
+	for _, level := range []int{OptimizeNone, OptimizePeephole} {
+		bytecode := compileForSerialize(t, input, level)
+
+		var buf bytes.Buffer
+		if err := bytecode.Serialize(&buf); err != nil {
+			t.Fatalf("serialize error: %s", err)
+		}
+		if !IsBytecode(buf.Bytes()) {
+			t.Fatalf("serialized data has no magic header")
+		}
+
+		loaded, err := Deserialize(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("deserialize error at level %d: %s", level, err)
+		}
+		if !reflect.DeepEqual(loaded, bytecode) {
+			t.Errorf("bytecode changed after round trip at level %d.\nwant=%#v\ngot=%#v", level, bytecode, loaded)
+		}
+	}
+}
+
+func TestDeserializeErrors(t *testing.T) {
+	bytecode := compileForSerialize(t, `let f = fn(x) { if (x) { 1 } else { 2 } }; f(true);`, OptimizeNone)
+	var buf bytes.Buffer
+	if err := bytecode.Serialize(&buf); err != nil {
+		t.Fatalf("serialize error: %s", err)
+	}
+	valid := buf.Bytes()
+
+	modified := func(f func(data []byte) []byte) []byte {
+		data := append([]byte{}, valid...)
+		return f(data)
+	}
+	// 主程序指令的长度在 header 和内置函数名之后
+	instructionsOffset := len(bytecodeMagic) + 2 + 4 + 4
+	for n := binary.BigEndian.Uint32(valid[instructionsOffset-4:]); n > 0; n-- {
+		instructionsOffset += 4 + int(binary.BigEndian.Uint32(valid[instructionsOffset:]))
+	}
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{"empty", []byte{}, "not a bytecode file"},
+		{"source", []byte("let x = 1;"), "not a bytecode file"},
+		{"format", modified(func(d []byte) []byte {
+			binary.BigEndian.PutUint16(d[4:], 99)
+			return d
+		}), "unsupported bytecode format 99"},
+		{"version", modified(func(d []byte) []byte {
+			binary.BigEndian.PutUint32(d[6:], code.Version()+1)
+			return d
+		}), "different opcode set"},
+		{"truncated", valid[:len(valid)-3], "unexpected end of bytecode"},
+		{"trailing", append(append([]byte{}, valid...), 0), "unexpected 1 bytes after constants"},
+		{"opcode", modified(func(d []byte) []byte {
+			d[instructionsOffset+4] = 255
+			return d
+		}), "main program: offset 0: opcode 255 undefined"},
+		{"constant", modified(func(d []byte) []byte {
+			// 第一条指令是 OpClosure,把常量下标改成不存在的值
+			binary.BigEndian.PutUint16(d[instructionsOffset+5:], 500)
+			return d
+		}), "main program: offset 0: constant 500 out of range"},
+	}
+
+	for _, tt := range tests {
+		_, err := Deserialize(bytes.NewReader(tt.data))
+		if err == nil {
+			t.Errorf("%s: expected error", tt.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("%s: wrong error. want=%q, got=%q", tt.name, tt.expected, err)
+		}
+	}
+}
+
+func TestDeserializeInvalidOperands(t *testing.T) {
+	concat := func(ins ...[]byte) code.Instructions {
+		out := code.Instructions{}
+		for _, i := range ins {
+			out = append(out, i...)
+		}
+		return out
+	}
+	closure := func(numFree int, body ...[]byte) *Bytecode {
+		return &Bytecode{
+			Instructions: concat(code.Make(code.OpClosure, 0, numFree), code.Make(code.OpPop)),
+			Constants:    []object.Object{&object.CompiledFunction{Instructions: concat(body...)}},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		bytecode *Bytecode
+		expected string
+	}{
+		{"free", closure(0, code.Make(code.OpGetFree, 0), code.Make(code.OpReturnValue)),
+			"constant 0: offset 0: free variable 0 out of range"},
+		{"set free", closure(0, code.Make(code.OpNull), code.Make(code.OpSetFree, 3), code.Make(code.OpReturn)),
+			"constant 0: offset 1: free variable 3 out of range"},
+		{"capture free", closure(0, code.Make(code.OpCaptureFree, 1), code.Make(code.OpReturnValue)),
+			"constant 0: offset 0: free variable 1 out of range"},
+		{"global", &Bytecode{
+			Instructions: concat(code.Make(code.OpGetGlobal, 5), code.Make(code.OpPop)),
+			NumGlobals:   1,
+		}, "main program: offset 0: global 5 out of range"},
+		{"set global", &Bytecode{
+			Instructions: concat(code.Make(code.OpTrue), code.Make(code.OpSetGlobal, 0)),
+		}, "main program: offset 1: global 0 out of range"},
+		{"underflow", &Bytecode{
+			Instructions: concat(code.Make(code.OpTrue), code.Make(code.OpAdd)),
+		}, "main program: offset 1: OpAdd pops 2 values from a stack of 1"},
+		{"underflow after jump", &Bytecode{
+			Instructions: concat(code.Make(code.OpJump, 4), code.Make(code.OpTrue), code.Make(code.OpPop)),
+		}, "main program: offset 4: OpPop pops 1 values from a stack of 0"},
+		{"underflow in function", closure(0, code.Make(code.OpReturnValue)),
+			"constant 0: offset 0: OpReturnValue pops 1 values from a stack of 0"},
+		{"closure free values", closure(2, code.Make(code.OpReturn)),
+			"main program: offset 0: OpClosure pops 2 values from a stack of 0"},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := tt.bytecode.Serialize(&buf); err != nil {
+			t.Fatalf("%s: serialize error: %s", tt.name, err)
+		}
+		_, err := Deserialize(&buf)
+		if err == nil {
+			t.Errorf("%s: expected error", tt.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("%s: wrong error. want=%q, got=%q", tt.name, tt.expected, err)
+		}
+	}
+}
@@ -1,5 +1,10 @@
 package compiler
 
+import (
+	"monkey/object"
+	"sort"
+)
+
 type SymbolScope string
 
 const (
@@ -41,6 +46,10 @@ func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
 }
 
 func (s *SymbolTable) Define(name string) Symbol {
+	// 同一作用域里重复 let 复用原来的位置,这样 "let x = x + 1" 读到的是旧值
+	if existing, ok := s.store[name]; ok && (existing.Scope == GlobalScope || existing.Scope == LocalScope) {
+		return existing
+	}
 	symbol := Symbol{Name: name, Index: s.numDefinitions}
 	if s.Outer == nil {
 		symbol.Scope = GlobalScope
@@ -74,6 +83,14 @@ func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
 	s.store[name] = symbol
 	return symbol
 }
+
+// DefineBuiltins 按 Registry 里的下标定义所有内置函数
+func (s *SymbolTable) DefineBuiltins(r *object.Registry) {
+	for i, builtin := range r.Builtins() {
+		s.DefineBuiltin(i, builtin.Name)
+	}
+}
+
 func (s *SymbolTable) defineFree(original Symbol) Symbol {
 	s.FreeSymbols = append(s.FreeSymbols, original)
 	symbol := Symbol{Name: original.Name, Index: len(s.FreeSymbols) - 1}
@@ -92,3 +109,17 @@ func (s *SymbolTable) DefineFunctionName(name string) Symbol {
 	s.store[name] = symbol
 	return symbol
 }
+
+// GlobalSymbols 按索引顺序返回定义在全局作用域中的符号
+func (s *SymbolTable) GlobalSymbols() []Symbol {
+	symbols := []Symbol{}
+	for _, symbol := range s.store {
+		if symbol.Scope == GlobalScope {
+			symbols = append(symbols, symbol)
+		}
+	}
+	sort.Slice(symbols, func(i, j int) bool {
+		return symbols[i].Index < symbols[j].Index
+	})
+	return symbols
+}
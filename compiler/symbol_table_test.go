@@ -2,6 +2,7 @@ package compiler
 
 
 import (
+	"monkey/object"
 	"testing"
 )
 
@@ -339,3 +340,69 @@ func TestShadowingFunctionName(t *testing.T) {
 			expected.Name, expected, result)
 	}
 }
+
+func TestGlobalSymbols(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineBuiltin(0, "len")
+	global.Define("b")
+	global.Define("a")
+	global.Define("c")
+
+	local := NewEnclosedSymbolTable(global)
+	local.Define("d")
+
+	expected := []Symbol{
+		{Name: "b", Scope: GlobalScope, Index: 0},
+		{Name: "a", Scope: GlobalScope, Index: 1},
+		{Name: "c", Scope: GlobalScope, Index: 2},
+	}
+
+	result := global.GlobalSymbols()
+	if len(result) != len(expected) {
+		t.Fatalf("wrong number of global symbols. want=%d, got=%d", len(expected), len(result))
+	}
+	for i, sym := range expected {
+		if result[i] != sym {
+			t.Errorf("wrong symbol at %d. want=%+v, got=%+v", i, sym, result[i])
+		}
+	}
+}
+
+func TestRedefineInSameScope(t *testing.T) {
+	global := NewSymbolTable()
+	a := global.Define("a")
+	global.Define("b")
+
+	again := global.Define("a")
+	if again != a {
+		t.Errorf("expected redefinition to reuse %+v, got=%+v", a, again)
+	}
+
+	local := NewEnclosedSymbolTable(global)
+	shadow := local.Define("a")
+	expected := Symbol{Name: "a", Scope: LocalScope, Index: 0}
+	if shadow != expected {
+		t.Errorf("expected %+v, got=%+v", expected, shadow)
+	}
+}
+
+func TestDefineBuiltins(t *testing.T) {
+	registry := object.NewRegistry()
+	registry.Register("lookup", 1, func(caller object.Caller, args ...object.Object) object.Object { return nil })
+
+	global := NewSymbolTable()
+	global.DefineBuiltins(registry)
+	local := NewEnclosedSymbolTable(global)
+
+	for i, builtin := range registry.Builtins() {
+		expected := Symbol{Name: builtin.Name, Scope: BuiltinScope, Index: i}
+		result, ok := local.Resolve(builtin.Name)
+		if !ok {
+			t.Errorf("name %s not resolvable", builtin.Name)
+			continue
+		}
+		if result != expected {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", builtin.Name, expected, result)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"monkey/compiler"
+	"os"
+)
+
+// disasmCommand 执行 `monkey disasm <file>`,输出源文件或字节码文件的反汇编
+func disasmCommand(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "disasm: expected exactly one file name\n")
+		return 2
+	}
+	filename := args[0]
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "disasm: %s\n", err)
+		return 1
+	}
+	return disasmSource(os.Stdout, os.Stderr, filename, source)
+}
+
+// disasmSource 字节码文件直接加载,源文件先编译
+func disasmSource(out, errOut io.Writer, filename string, source []byte) int {
+	var bytecode *compiler.Bytecode
+	if compiler.IsBytecode(source) {
+		var err error
+		bytecode, err = compiler.Deserialize(bytes.NewReader(source))
+		if err != nil {
+			fmt.Fprintf(errOut, "disasm: %s: %s\n", filename, err)
+			return 1
+		}
+	} else {
+		program, ok := parseSource(errOut, filename, string(source))
+		if !ok {
+			return 1
+		}
+		var err error
+		bytecode, err = compileProgram(program)
+		if err != nil {
+			fmt.Fprintf(errOut, "compile error: %s\n", err)
+			return 1
+		}
+	}
+
+	if err := compiler.Disassemble(out, bytecode); err != nil {
+		fmt.Fprintf(errOut, "disasm: %s\n", err)
+		return 1
+	}
+	return 0
+}
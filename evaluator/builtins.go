@@ -4,11 +4,44 @@ import (
 	"monkey/object"
 )
 
-var builtins = map[string]*object.Builtin{
-	"len":   object.GetBuiltinByName("len"),
-	"first": object.GetBuiltinByName("first"),
-	"last":  object.GetBuiltinByName("last"),
-	"rest":  object.GetBuiltinByName("rest"),
-	"push":  object.GetBuiltinByName("push"),
-	"puts":  object.GetBuiltinByName("puts"),
+// builtins 环境没有设置 Registry 时使用的默认内置函数,只读
+var builtins = object.NewRegistry()
+
+// lookupBuiltin 环境设置了 Registry 时在里面查找,否则使用默认的内置函数
+func lookupBuiltin(env *object.Environment, name string) (*object.Builtin, bool) {
+	if registry := env.Builtins(); registry != nil {
+		return registry.Lookup(name)
+	}
+	return builtins.Lookup(name)
+}
+
+// Call 调用 Monkey 的函数或内置函数并返回结果,出错时返回 *object.Error。
+// Go 代码可以用它调用求值得到的函数,在求值的过程中重入也没有问题
+func Call(fn object.Object, args ...object.Object) object.Object {
+	return (&caller{}).Call(fn, args...)
+}
+
+// caller 传给内置函数的 object.Caller,env 是调用内置函数的环境,用来统计内存
+type caller struct {
+	env *object.Environment
+	//内置函数通过 Allocate 登记过的字节数
+	allocated int64
+}
+
+func (c *caller) Call(fn object.Object, args ...object.Object) object.Object {
+	if builtin, ok := fn.(*object.Builtin); ok {
+		return applyBuiltin(builtin, args, c.env)
+	}
+	return applyFunction(fn, args)
+}
+
+func (c *caller) Allocate(size int64) error {
+	c.allocated += size
+	if err := allocateBytes(c.env, size); err != nil {
+		return err.Err
+	}
+	if err := checkLimits(c.env); err != nil {
+		return err.Err
+	}
+	return nil
 }
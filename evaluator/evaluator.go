@@ -1,15 +1,22 @@
 package evaluator
 
 import (
+	"context"
 	"fmt"
+	"math"
 	"monkey/ast"
 	"monkey/object"
+	"monkey/token"
+	"strings"
 )
 
 var (
-	NULL  = &object.Null{}
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
+	NULL  = object.NULL
+	TRUE  = object.TRUE
+	FALSE = object.FALSE
+
+	BREAK    = &object.Break{}
+	CONTINUE = &object.Continue{}
 )
 
 func newError(format string, a ...interface{}) *object.Error {
@@ -17,7 +24,76 @@ func newError(format string, a ...interface{}) *object.Error {
 }
 
 // Eval ...
+// 产生的错误会带上最内层出错节点的位置
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	result := eval(node, env)
+	if err, ok := result.(*object.Error); ok && !err.Pos.IsValid() && node != nil {
+		err.Pos = node.Pos()
+	}
+	return result
+}
+
+// 执行被限制打断时错误对象的 Err,可以用 errors.Is 区分
+var (
+	ErrExecutionLimit = object.ErrExecutionLimit
+	ErrCancelled      = object.ErrCancelled
+	ErrMemoryLimit    = object.ErrMemoryLimit
+)
+
+// EvalContext 和 Eval 一样,但 ctx 被取消时停止求值并返回 Err 为 ErrCancelled 的错误。
+// 求值的步数超过 limits.MaxSteps 返回 ErrExecutionLimit,
+// 分配的内存超过 limits.MaxBytes 返回 ErrMemoryLimit
+func EvalContext(ctx context.Context, node ast.Node, env *object.Environment, limits object.Limits) object.Object {
+	limits.Done = ctx.Done()
+	env.SetLimits(&limits)
+	defer env.SetLimits(nil)
+	return Eval(node, env)
+}
+
+// checkLimits 每求值一个节点算一步
+func checkLimits(env *object.Environment) *object.Error {
+	limits := env.Limits()
+	if limits == nil {
+		return nil
+	}
+	limits.Steps++
+	if limits.MaxSteps > 0 && limits.Steps > limits.MaxSteps {
+		return object.WrapError(ErrExecutionLimit)
+	}
+	if limits.Done != nil {
+		select {
+		case <-limits.Done:
+			return object.WrapError(ErrCancelled)
+		default:
+		}
+	}
+	return nil
+}
+
+// allocate 把新建的 obj 计入内存限制,超出时返回错误,否则原样返回 obj
+func allocate(env *object.Environment, obj object.Object) object.Object {
+	if err := allocateBytes(env, object.SizeOf(obj)); err != nil {
+		return err
+	}
+	return obj
+}
+
+func allocateBytes(env *object.Environment, size int64) *object.Error {
+	limits := env.Limits()
+	if limits == nil {
+		return nil
+	}
+	limits.Bytes += size
+	if limits.MaxBytes > 0 && limits.Bytes > limits.MaxBytes {
+		return object.WrapError(ErrMemoryLimit)
+	}
+	return nil
+}
+
+func eval(node ast.Node, env *object.Environment) object.Object {
+	if err := checkLimits(env); err != nil {
+		return err
+	}
 	switch node := node.(type) {
 	// 语句
 	case *ast.Program:
@@ -26,6 +102,8 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return Eval(node.Expression, env)
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 	case *ast.PrefixExpression:
@@ -35,6 +113,9 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		}
 		return evalPrefixExpression(node.Operator, right)
 	case *ast.InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			return evalLogicalExpression(node, env)
+		}
 		left := Eval(node.Left, env)
 		if isError(left) {
 			return left
@@ -43,7 +124,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(right) {
 			return right
 		}
-		return evalInfixExpression(node.Operator, left, right)
+		return allocate(env, evalInfixExpression(node.Operator, left, right))
 	case *ast.BlockStatement:
 		return evalBlockStatement(node, env)
 	case *ast.IfExpression:
@@ -60,6 +141,18 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return val
 		}
 		env.Set(node.Name.Value, val)
+	case *ast.AssignExpression:
+		return evalAssignExpression(node, env)
+	case *ast.IndexAssignExpression:
+		return evalIndexAssignExpression(node, env)
+	case *ast.WhileStatement:
+		return evalWhileStatement(node, env)
+	case *ast.ForStatement:
+		return evalForStatement(node, env)
+	case *ast.BreakStatement:
+		return BREAK
+	case *ast.ContinueStatement:
+		return CONTINUE
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
 	case *ast.FunctionLiteral:
@@ -78,7 +171,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if len(args) == 1 && isError(args[0]) {
 			return args[0]
 		}
-		return applyFunction(function, args)
+		return (&caller{env: env}).Call(function, args...)
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
 	case *ast.ArrayLiteral:
@@ -86,7 +179,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if len(elements) == 1 && isError(elements[0]) {
 			return elements[0]
 		}
-		return &object.Array{Elements: elements}
+		return allocate(env, &object.Array{Elements: elements})
 	case *ast.IndexExpression:
 		left := Eval(node.Left, env)
 		if isError(left) {
@@ -98,7 +191,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		}
 		return evalIndexExpression(left, index)
 	case *ast.HashLiteral:
-		return evalHashLiteral(node, env)
+		return allocate(env, evalHashLiteral(node, env))
 	}
 	return nil
 }
@@ -112,11 +205,23 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 			return result.Value
 		case *object.Error:
 			return result
+		case *object.Break, *object.Continue:
+			err := loopControlError(result)
+			err.Pos = statement.Pos()
+			return err
 		}
 	}
 	return result
 }
 
+// loopControlError break 或 continue 到了程序或函数的边界还没有遇到循环,和编译器报同样的错误
+func loopControlError(obj object.Object) *object.Error {
+	if obj == BREAK {
+		return newError("break outside of a loop")
+	}
+	return newError("continue outside of a loop")
+}
+
 func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
 	var result object.Object
 
@@ -124,7 +229,8 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 		result = Eval(statement, env)
 		if result != nil {
 			rt := result.Type()
-			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ ||
+				rt == object.BREAK_OBJ || rt == object.CONTINUE_OBJ {
 				return result
 			}
 		}
@@ -132,6 +238,183 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 	return result
 }
 
+// evalAssignExpression ...
+// "x += 1" 先按 "x + 1" 求值再赋给 x
+func evalAssignExpression(node *ast.AssignExpression, env *object.Environment) object.Object {
+	name := node.Name.Value
+
+	var current object.Object
+	if node.Operator != "=" {
+		var ok bool
+		current, ok = env.Get(name)
+		if !ok {
+			return assignError(env, name)
+		}
+	}
+
+	val := Eval(node.Value, env)
+	if isError(val) {
+		return val
+	}
+
+	if current != nil {
+		operator := strings.TrimSuffix(node.Operator, "=")
+		val = allocate(env, evalInfixExpression(operator, current, val))
+		if isError(val) {
+			return val
+		}
+	}
+
+	if !env.Assign(name, val) {
+		return assignError(env, name)
+	}
+	return val
+}
+
+// evalIndexAssignExpression ...
+// 按 left、index、value 的顺序求值,然后直接修改数组或哈希表
+func evalIndexAssignExpression(node *ast.IndexAssignExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+	index := Eval(node.Index, env)
+	if isError(index) {
+		return index
+	}
+
+	var current object.Object
+	if node.Operator != "=" {
+		current = evalIndexExpression(left, index)
+		if isError(current) {
+			return current
+		}
+	}
+
+	val := Eval(node.Value, env)
+	if isError(val) {
+		return val
+	}
+
+	if current != nil {
+		operator := strings.TrimSuffix(node.Operator, "=")
+		val = allocate(env, evalInfixExpression(operator, current, val))
+		if isError(val) {
+			return val
+		}
+	}
+
+	if hash, ok := left.(*object.Hash); ok && !hasKey(hash, index) {
+		if err := allocateBytes(env, object.HashPairSize); err != nil {
+			return err
+		}
+	}
+	return setIndex(left, index, val)
+}
+
+func hasKey(hash *object.Hash, index object.Object) bool {
+	key, ok := index.(object.Hashable)
+	if !ok {
+		return false
+	}
+	_, ok = hash.Pairs[key.HashKey()]
+	return ok
+}
+
+// setIndex 修改数组的元素或哈希表的键值对,返回赋进去的值
+func setIndex(left, index, val object.Object) object.Object {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		arrayObject := left.(*object.Array)
+		idx := index.(*object.Integer).Value
+		if idx < 0 || idx >= int64(len(arrayObject.Elements)) {
+			return newError("index out of range: %d (len %d)", idx, len(arrayObject.Elements))
+		}
+		arrayObject.Elements[idx] = val
+		return val
+	case left.Type() == object.HASH_OBJ:
+		hashObject := left.(*object.Hash)
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+		hashObject.Pairs[key.HashKey()] = object.HashPair{Key: index, Value: val}
+		return val
+	default:
+		return newError("index assignment not supported: %s", left.Type())
+	}
+}
+
+func assignError(env *object.Environment, name string) *object.Error {
+	if _, ok := lookupBuiltin(env, name); ok {
+		return newError("cannot assign to builtin: %s", name)
+	}
+	return newError("identifier not found: " + name)
+}
+
+// evalWhileStatement ...
+// 循环本身不产生值,循环体里的 return 和错误会继续向外传递
+func evalWhileStatement(ws *ast.WhileStatement, env *object.Environment) object.Object {
+	for {
+		condition := Eval(ws.Condition, env)
+		if isError(condition) {
+			return condition
+		}
+		if !isTruthy(condition) {
+			return nil
+		}
+
+		result := Eval(ws.Body, env)
+		if result == BREAK {
+			return nil
+		}
+		if isLoopExit(result) {
+			return result
+		}
+	}
+}
+
+// evalForStatement ...
+// 数组按下标顺序遍历元素,哈希表按排好序的键遍历
+func evalForStatement(fs *ast.ForStatement, env *object.Environment) object.Object {
+	iterable := Eval(fs.Iterable, env)
+	if isError(iterable) {
+		return iterable
+	}
+
+	var elements []object.Object
+	switch iterable := iterable.(type) {
+	case *object.Array:
+		elements = iterable.Elements
+	case *object.Hash:
+		elements = iterable.Keys()
+	default:
+		return newError("not iterable: %s", iterable.Type())
+	}
+
+	for _, element := range elements {
+		env.Set(fs.Variable.Value, element)
+
+		result := Eval(fs.Body, env)
+		if result == BREAK {
+			return nil
+		}
+		if isLoopExit(result) {
+			return result
+		}
+	}
+	return nil
+}
+
+// isLoopExit 循环体的结果是 return 或错误时需要结束整个循环
+func isLoopExit(obj object.Object) bool {
+	if obj == nil {
+		return false
+	}
+	rt := obj.Type()
+	return rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ
+}
+
 // nativeBoolToBooleanObject ...
 func nativeBoolToBooleanObject(input bool) *object.Boolean {
 	if input {
@@ -169,18 +452,22 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 
 // evalMinusPrefixOperatorExpression ...
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
-	if right.Type() != object.INTEGER_OBJ {
+	switch right := right.(type) {
+	case *object.Integer:
+		return &object.Integer{Value: -right.Value}
+	case *object.Float:
+		return &object.Float{Value: -right.Value}
+	default:
 		return newError("unknown operator: -%s", right.Type())
 	}
-
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
 }
 
 func evalInfixExpression(operator string, left, right object.Object) object.Object {
 	switch {
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfixExpression(operator, left, right)
+	case isNumber(left) && isNumber(right):
+		return evalFloatInfixExpression(operator, left, right)
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
 		return evalStringInfixExpression(operator, left, right)
 	case operator == "==":
@@ -205,11 +492,55 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 	case "*":
 		return &object.Integer{Value: leftVal * rightVal}
 	case "/":
+		if rightVal == 0 {
+			return newError("division by zero: %d / %d", leftVal, rightVal)
+		}
 		return &object.Integer{Value: leftVal / rightVal}
+	case "%":
+		if rightVal == 0 {
+			return newError("division by zero: %d %% %d", leftVal, rightVal)
+		}
+		return &object.Integer{Value: leftVal % rightVal}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+// evalFloatInfixExpression 至少有一边是浮点数时,整数会被提升为浮点数
+func evalFloatInfixExpression(operator string, left, right object.Object) object.Object {
+	leftVal := toFloat(left)
+	rightVal := toFloat(right)
+	switch operator {
+	case "-":
+		return &object.Float{Value: leftVal - rightVal}
+	case "+":
+		return &object.Float{Value: leftVal + rightVal}
+	case "*":
+		return &object.Float{Value: leftVal * rightVal}
+	case "/":
+		return &object.Float{Value: leftVal / rightVal}
+	case "%":
+		return &object.Float{Value: math.Mod(leftVal, rightVal)}
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
 	case ">":
 		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
 	case "==":
 		return nativeBoolToBooleanObject(leftVal == rightVal)
 	case "!=":
@@ -219,8 +550,48 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 	}
 }
 
+// isNumber ...
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
+// toFloat 把整数或浮点数转换成float64
+func toFloat(obj object.Object) float64 {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value)
+	case *object.Float:
+		return obj.Value
+	default:
+		return 0
+	}
+}
+
+// evalLogicalExpression && 和 || 是短路求值的,结果总是布尔值
+func evalLogicalExpression(node *ast.InfixExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+	if node.Operator == "&&" && !isTruthy(left) {
+		return FALSE
+	}
+	if node.Operator == "||" && isTruthy(left) {
+		return TRUE
+	}
+
+	right := Eval(node.Right, env)
+	if isError(right) {
+		return right
+	}
+	return nativeBoolToBooleanObject(isTruthy(right))
+}
+
 func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
 	condition := Eval(ie.Condition, env)
+	if isError(condition) {
+		return condition
+	}
 	if isTruthy(condition) {
 		return Eval(ie.Consequence, env)
 	} else if ie.Alternative != nil {
@@ -234,7 +605,7 @@ func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object
 	if val, ok := env.Get(node.Value); ok {
 		return val
 	}
-	if builtin, ok := builtins[node.Value]; ok {
+	if builtin, ok := lookupBuiltin(env, node.Value); ok {
 		return builtin
 	}
 
@@ -276,17 +647,55 @@ func evalExpressions(
 	return result
 }
 
+// applyFunction ...
+// 函数体以尾调用结束时在这里循环执行下一次调用,而不是递归
 func applyFunction(fn object.Object, args []object.Object) object.Object {
+	var pos token.Position
+	for {
+		result := callFunction(fn, args)
+		call, ok := result.(*tailCall)
+		if !ok {
+			if err, ok := result.(*object.Error); ok && !err.Pos.IsValid() {
+				err.Pos = pos
+			}
+			return result
+		}
+		fn, args, pos = call.fn, call.args, call.pos
+	}
+}
+
+// applyBuiltin ...
+// 内置函数返回的数组、字符串和哈希表都当作新分配的计入内存限制,是偏大的估计。
+// 内置函数已经通过 Allocate 登记过的部分不再重复计算
+func applyBuiltin(builtin *object.Builtin, args []object.Object, env *object.Environment) object.Object {
+	c := &caller{env: env}
+	result := builtin.Fn(c, args...)
+	if result == nil {
+		result = NULL
+	}
+	if size := object.SizeOf(result) - c.allocated; size > 0 {
+		if err := allocateBytes(env, size); err != nil {
+			return err
+		}
+	}
+	return result
+}
+
+// callFunction 调用一次函数,函数体最后的调用作为 *tailCall 返回
+func callFunction(fn object.Object, args []object.Object) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
+		if len(args) != len(fn.Parameters) {
+			return newError("wrong number of arguments: want=%d, got=%d", len(fn.Parameters), len(args))
+		}
 		extendedEnv := extendFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, extendedEnv)
+		evaluated := evalTailPosition(fn.Body, extendedEnv)
+		if evaluated == BREAK || evaluated == CONTINUE {
+			return loopControlError(evaluated)
+		}
 		return unwrapReturnValue(evaluated)
 	case *object.Builtin:
-		if result := fn.Fn(args...);result != nil{
-			return result
-		}
-		return NULL
+		return applyBuiltin(fn, args, nil)
 	default:
 		return newError("not a function: %s", fn.Type())
 	}
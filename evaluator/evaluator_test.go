@@ -1,10 +1,13 @@
 package evaluator
 
 import (
+	"context"
+	"errors"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
 	"testing"
+	"time"
 )
 
 func TestEvalIntegerExpression(t *testing.T) {
@@ -35,6 +38,60 @@ func TestEvalIntegerExpression(t *testing.T) {
 	}
 }
 
+func TestModuloOperator(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"7 % 3", int64(1)},
+		{"-7 % 3", int64(-1)},
+		{"6 % 3 + 1", int64(1)},
+		{"7.5 % 2", 1.5},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int64:
+			testIntegerObject(t, evaluated, expected)
+		case float64:
+			testFloatObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.5", 3.5},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3.0},
+		{"1 + 0.5", 1.5},
+		{"0.5 * 4", 2.0},
+		{"7 / 2.0", 3.5},
+		{"1e3 - 1", 999},
+		{"(1.5 + 2) * -2", -7},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testFloatObject(t, evaluated, tt.expected)
+	}
+}
+
+func testFloatObject(t *testing.T, obj object.Object, expected float64) bool {
+	result, ok := obj.(*object.Float)
+	if !ok {
+		t.Errorf("object is not Float. got=%T (%+v)", obj, obj)
+		return false
+	}
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got=%g, want=%g", result.Value, expected)
+		return false
+	}
+	return true
+}
+
 func testEval(input string) object.Object {
 	l := lexer.New(input)
 	p := parser.New(l)
@@ -84,6 +141,26 @@ func TestEvalBoolenaExpression(t *testing.T) {
 		{"(1 < 2) == false", false},
 		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
+		{"1.5 < 2", true},
+		{"2 > 2.5", false},
+		{"1 == 1.0", true},
+		{"0.1 + 0.2 != 0.3", true},
+		{"1 <= 1", true},
+		{"2 <= 1", false},
+		{"1 >= 1", true},
+		{"1 >= 2", false},
+		{"1.5 >= 1", true},
+		{"1 <= 0.5", false},
+		{"true && true", true},
+		{"true && false", false},
+		{"1 && 2", true},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 < 2 && 2 < 3", true},
+		{"1 > 2 || 2 > 3", false},
+		{"false && foobar", false},
+		{"true || foobar", true},
+		{"let x = 0; (x != 0 && 10 / x > 1) || x == 0", true},
 	}
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
@@ -233,6 +310,18 @@ if (10 > 1) {
 			`{"name":"Monkey"}[fn(x){x}];`,
 			"unusable as hash key: FUNCTION",
 		},
+		{
+			"1 / 0",
+			"division by zero: 1 / 0",
+		},
+		{
+			"5 % 0",
+			"division by zero: 5 % 0",
+		},
+		{
+			"true && foobar",
+			"identifier not found: foobar",
+		},
 	}
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
@@ -251,6 +340,32 @@ if (10 > 1) {
 	}
 }
 
+func TestErrorPosition(t *testing.T) {
+	tests := []struct {
+		input       string
+		expectedPos string
+	}{
+		{"5 + true;", "1:3"},
+		{"let a = 1;\nlet f = fn() {\n  a - \"x\"\n};\nf();", "3:5"},
+		{"let x = 1;\n   foobar", "2:4"},
+		{"len(1)", "1:4"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got= %T (%+v)",
+				evaluated, evaluated)
+			continue
+		}
+		if errObj.Pos.String() != tt.expectedPos {
+			t.Errorf("wrong error position. expected=%q, got=%q",
+				tt.expectedPos, errObj.Pos.String())
+		}
+	}
+}
+
 func TestLetStatements(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -535,6 +650,14 @@ func TestHashIndexExpressions(t *testing.T) {
             `{false: 5}[false]`,
             5,
         },
+        {
+            `{5: 5}[5.0]`,
+            5,
+        },
+        {
+            `{5.0: 5}[5]`,
+            5,
+        },
     }
 
     for _, tt := range tests {
@@ -547,3 +670,433 @@ func TestHashIndexExpressions(t *testing.T) {
         }
     }
 }
+
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"while (false) { 1 }", nil},
+		{"let f = fn() { while (true) { return 5; } }; f()", 5},
+		{"let f = fn() { while (true) { break; } 7 }; f()", 7},
+		{"let f = fn(arr) { for (x in arr) { if (x > 2) { return x; } } }; f([1, 2, 3, 4])", 3},
+		{"let f = fn(arr) { for (x in arr) { if (x < 3) { continue; } return x * 10; } }; f([1, 2, 3])", 30},
+		{"let f = fn() { for (x in [1, 2]) { break; } x }; f()", 1},
+		{"let f = fn(h) { for (k in h) { return h[k]; } }; f({3: 30, 1: 10, 2: 20})", 10},
+		{"let f = fn() { for (x in []) { return 1; } 0 }; f()", 0},
+		{"let f = fn() { for (x in [1]) { for (y in [2, 3]) { break; } return x + y; } }; f()", 3},
+		{"for (x in 5) { x }", "not iterable: INTEGER"},
+		{"while (1 + true) { 1 }", "type mismatch: INTEGER + BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		default:
+			if evaluated != nil {
+				t.Errorf("loop produced a value. got=%T (%+v)", evaluated, evaluated)
+			}
+		}
+	}
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let x = 1; x = 5; x", 5},
+		{"let x = 1; x = 5", 5},
+		{"let x = 1; x += 2; x", 3},
+		{"let x = 5; x -= 2; x", 3},
+		{"let x = 5; x *= 2; x", 10},
+		{"let x = 10; x /= 4; x", 2},
+		{"let x = 1; let y = 2; x = y = 7; x + y", 14},
+		{"let x = 1; let f = fn() { x = 10; }; f(); x", 10},
+		{"let f = fn() { let c = 0; let inc = fn() { c += 1; }; inc(); inc(); c }; f()", 2},
+		{"let sum = 0; for (x in [1, 2, 3, 4]) { sum += x; } sum", 10},
+		{"let i = 0; while (i < 5) { i += 1; } i", 5},
+		{"let x = 1; let x = x + 1; x", 2},
+		{"y = 1", "identifier not found: y"},
+		{"y += 1", "identifier not found: y"},
+		{"len = 1", "cannot assign to builtin: len"},
+		{"let x = 1; x += true", "type mismatch: INTEGER + BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestIndexAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let a = [1, 2, 3]; a[1] = 5; a[1]", 5},
+		{"let a = [1, 2, 3]; a[0] = 7", 7},
+		{"let a = [1, 2, 3]; a[2] += 10; a[2]", 13},
+		{"let a = [1, 2, 3]; let b = a; b[0] = 9; a[0]", 9},
+		{"let m = [[1, 2], [3, 4]]; m[1][0] *= 5; m[1][0]", 15},
+		{`let h = {}; h["k"] = 1; h["k"]`, 1},
+		{`let h = {"k": 1}; h["k"] -= 3; h["k"]`, -2},
+		{`let h = {}; for (i in [1, 2, 3]) { h[i] = i * i; } h[3]`, 9},
+		{"let a = [1]; a[1] = 2", "index out of range: 1 (len 1)"},
+		{"let a = [1]; a[-1] = 2", "index out of range: -1 (len 1)"},
+		{"let h = {}; h[fn(x) { x }] = 1", "unusable as hash key: FUNCTION"},
+		{`let s = "abc"; s[0] = "x"`, "index assignment not supported: STRING"},
+		{`let h = {}; h["k"] += 1`, "type mismatch: NULL + INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let loop = fn(n, acc) { if (n == 0) { acc } else { loop(n - 1, acc + n) } }; loop(100000, 0)", 5000050000},
+		{`let build = fn(n, acc) { if (n == 0) { return acc; } build(n - 1, push(acc, n)) };
+let sum = fn(arr, i, acc) { if (i == len(arr)) { return acc; } sum(arr, i + 1, acc + arr[i]) };
+sum(build(5000, []), 0, 0)`, 12502500},
+		{"let f = fn(a) { len(a) }; f([1, 2, 3])", 3},
+		{"let f = fn(n) { if (n == 0) { fn() { n } } else { f(n - 1) } }; f(3)()", 0},
+		{"let f = fn() { let x = 1; return x + 1; 5 }; f()", 2},
+		{"let f = fn(x) { if (x > 0) { 1 } }; f(0)", nil},
+		{"let f = fn(a) { a(1) }; f(5)", "1:18: not a function: INTEGER"},
+		{"let f = fn() { len(1) }; f()", "1:19: argument to `len` not supported, got INTEGER"},
+		{"let f = fn(x) { if (x + true) { 1 } }; f(1)", "1:23: type mismatch: INTEGER + BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case nil:
+			testNullObject(t, evaluated)
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if got := errObj.Pos.String() + ": " + errObj.Message; got != expected {
+				t.Errorf("wrong error. expected=%q, got=%q", expected, got)
+			}
+		}
+	}
+}
+
+func TestExecutionLimits(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		input    string
+		ctx      context.Context
+		maxSteps int
+		expected error
+	}{
+		{"while (true) { }", context.Background(), 1000, ErrExecutionLimit},
+		{"let f = fn(n) { f(n + 1) }; f(0)", context.Background(), 5000, ErrExecutionLimit},
+		{"let i = 0; while (i < 100000) { i += 1 }", context.Background(), 100, ErrExecutionLimit},
+		{"while (true) { }", cancelled, 0, ErrCancelled},
+		{"range(1099511627776)", context.Background(), 1000, ErrExecutionLimit},
+		{"map(range(1099511627776), fn(x) { x })", context.Background(), 1000, ErrExecutionLimit},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := EvalContext(tt.ctx, program, object.NewEnvironment(), object.Limits{MaxSteps: tt.maxSteps})
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned for %q. got=%T(%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if !errors.Is(errObj.Err, tt.expected) {
+			t.Errorf("wrong error for %q. expected=%v, got=%q", tt.input, tt.expected, errObj.Message)
+		}
+	}
+}
+
+func TestExecutionLimitsTimeout(t *testing.T) {
+	program := parser.New(lexer.New("range(1099511627776)")).ParseProgram()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	evaluated := EvalContext(ctx, program, object.NewEnvironment(), object.Limits{})
+	errObj, ok := evaluated.(*object.Error)
+	if !ok || !errors.Is(errObj.Err, ErrCancelled) {
+		t.Fatalf("expected %v error, got=%T(%+v)", ErrCancelled, evaluated, evaluated)
+	}
+}
+
+func TestExecutionLimitsAllowWithinBudget(t *testing.T) {
+	program := parser.New(lexer.New("let i = 0; while (i < 100) { i += 1 }; i")).ParseProgram()
+	env := object.NewEnvironment()
+
+	evaluated := EvalContext(context.Background(), program, env, object.Limits{MaxSteps: 10000})
+	testIntegerObject(t, evaluated, 100)
+	if env.Limits() != nil {
+		t.Errorf("limits not cleared after EvalContext")
+	}
+}
+
+func TestMemoryLimits(t *testing.T) {
+	tests := []struct {
+		input    string
+		maxBytes int64
+	}{
+		{"let a = []; while (true) { a = push(a, 1) }", 1 << 20},
+		{`let s = "x"; while (true) { s = s + s }`, 1 << 20},
+		{`let s = "x"; while (true) { s += s }`, 1 << 20},
+		{"let h = {}; let i = 0; while (true) { h[i] = i; i += 1 }", 1 << 16},
+		{"let f = fn(a) { f(push(a, 1)) }; f([])", 1 << 20},
+		{"[1, 2, 3, 4, 5, 6, 7, 8]", 100},
+		{`{"a": 1, "b": 2}`, 100},
+		{"range(1099511627776)", 1 << 20},
+		{"range(9223372036854775807, -9223372036854775807, -1)", 1 << 20},
+		{"let a = range(1000); concat(a, a, a, a)", 1 << 15},
+		{"let a = range(1000); slice(a, 1)", 1 << 14},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := EvalContext(context.Background(), program, object.NewEnvironment(), object.Limits{MaxBytes: tt.maxBytes})
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned for %q. got=%T(%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if !errors.Is(errObj.Err, ErrMemoryLimit) {
+			t.Errorf("wrong error for %q. expected=%v, got=%q", tt.input, ErrMemoryLimit, errObj.Message)
+		}
+	}
+}
+
+func TestMemoryLimitsAllowWithinBudget(t *testing.T) {
+	input := `let a = []; let i = 0; while (i < 10) { a = push(a, "item"); i += 1 }; len(a)`
+	program := parser.New(lexer.New(input)).ParseProgram()
+	env := object.NewEnvironment()
+
+	evaluated := EvalContext(context.Background(), program, env, object.Limits{MaxBytes: 1 << 16})
+	testIntegerObject(t, evaluated, 10)
+}
+
+func TestCustomBuiltins(t *testing.T) {
+	registry := object.NewRegistry()
+	registry.Register("lookupUser", 1, func(caller object.Caller, args ...object.Object) object.Object {
+		return &object.String{Value: "user-" + args[0].Inspect()}
+	})
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"lookupUser(7)", "user-7"},
+		{"let f = fn(id) { lookupUser(id) }; f(8)", "user-8"},
+		{"len(lookupUser(1))", ""},
+		{"lookupUser()", "wrong number of arguments. got=0, want=1"},
+		{"lookupUser = 1", "cannot assign to builtin: lookupUser"},
+	}
+
+	for _, tt := range tests {
+		env := object.NewEnvironment()
+		env.SetBuiltins(registry)
+		evaluated := Eval(parser.New(lexer.New(tt.input)).ParseProgram(), env)
+		switch result := evaluated.(type) {
+		case *object.String:
+			if result.Value != tt.expected {
+				t.Errorf("wrong result for %q. want=%q, got=%q", tt.input, tt.expected, result.Value)
+			}
+		case *object.Integer:
+			testIntegerObject(t, result, 6)
+		case *object.Error:
+			if result.Message != tt.expected {
+				t.Errorf("wrong error for %q. want=%q, got=%q", tt.input, tt.expected, result.Message)
+			}
+		default:
+			t.Errorf("unexpected result for %q. got=%T(%+v)", tt.input, evaluated, evaluated)
+		}
+	}
+
+	evaluated := testEval("lookupUser(1)")
+	if errObj, ok := evaluated.(*object.Error); !ok || errObj.Message != "identifier not found: lookupUser" {
+		t.Errorf("custom builtin visible without registry. got=%+v", evaluated)
+	}
+}
+
+func TestBuiltinCallbacks(t *testing.T) {
+	registry := object.NewRegistry()
+	registry.Register("apply", 2, func(caller object.Caller, args ...object.Object) object.Object {
+		return caller.Call(args[0], args[1])
+	})
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"apply(fn(x) { x * 2 }, 21)", 42},
+		{`apply(len, "four")`, 4},
+		{"let n = 10; apply(fn(x) { x + n }, 1) + 1", 12},
+		{"apply(fn(x) { apply(fn(y) { y + 1 }, x) * 3 }, 1)", 6},
+		{"let f = fn(x) { if (x == 0) { 0 } else { apply(f, x - 1) + 1 } }; f(20)", 20},
+		{"apply(fn(x) { x + true }, 1)", "type mismatch: INTEGER + BOOLEAN"},
+		{"apply(fn() { 1 }, 1)", "wrong number of arguments: want=0, got=1"},
+		{"let f = fn(x) { apply(fn(y) { y + true }, x) }; f(1); 5", "type mismatch: INTEGER + BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		env := object.NewEnvironment()
+		env.SetBuiltins(registry)
+		evaluated := Eval(parser.New(lexer.New(tt.input)).ParseProgram(), env)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned for %q. got=%T(%+v)", tt.input, evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error for %q. expected=%q, got=%q", tt.input, expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestDefaultBuiltinsMatchObject(t *testing.T) {
+	env := object.NewEnvironment()
+	for _, def := range object.Builtins {
+		builtin, ok := lookupBuiltin(env, def.Name)
+		if !ok {
+			t.Errorf("builtin %s missing from the evaluator", def.Name)
+			continue
+		}
+		if builtin != def.Builtin {
+			t.Errorf("builtin %s is not the one from object.Builtins", def.Name)
+		}
+	}
+}
+
+func TestCall(t *testing.T) {
+	env := object.NewEnvironment()
+	Eval(parser.New(lexer.New("let n = 5; let add = fn(a, b) { a + b + n };")).ParseProgram(), env)
+	add, _ := env.Get("add")
+
+	testIntegerObject(t, Call(add, &object.Integer{Value: 1}, &object.Integer{Value: 2}), 8)
+	testIntegerObject(t, Call(object.GetBuiltinByName("len"), &object.String{Value: "four"}), 4)
+
+	errorTests := []struct {
+		fn       object.Object
+		args     []object.Object
+		expected string
+	}{
+		{add, []object.Object{&object.Integer{Value: 1}}, "wrong number of arguments: want=2, got=1"},
+		{add, []object.Object{&object.Integer{Value: 1}, TRUE}, "type mismatch: INTEGER + BOOLEAN"},
+		{&object.Integer{Value: 1}, nil, "not a function: INTEGER"},
+	}
+	for _, tt := range errorTests {
+		errObj, ok := Call(tt.fn, tt.args...).(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned for %s", tt.fn.Inspect())
+			continue
+		}
+		if errObj.Message != tt.expected {
+			t.Errorf("wrong error. expected=%q, got=%q", tt.expected, errObj.Message)
+		}
+	}
+}
+
+func TestCollectionBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"map([1, 2, 3], fn(x) { x * 2 })", "[2, 4, 6]"},
+		{`map(["a", "bb"], len)`, "[1, 2]"},
+		{"filter([1, 2, 3, 4], fn(x) { x % 2 == 0 })", "[2, 4]"},
+		{"reduce([1, 2, 3, 4], fn(acc, x) { acc + x }, 0)", 10},
+		{"sort([3, 1, 2])", "[1, 2, 3]"},
+		{"sort([3, 1, 2], fn(a, b) { a > b })", "[3, 2, 1]"},
+		{`sort(["b", "a"])`, "[a, b]"},
+		{"range(2, 8, 2)", "[2, 4, 6]"},
+		{"reverse([1, 2, 3])", "[3, 2, 1]"},
+		{"if (contains([1, 2, 3], 2) == true) { 1 } else { 0 }", 1},
+		{`index_of("monkey", "key")`, 3},
+		{"slice([1, 2, 3, 4], 1, -1)", "[2, 3]"},
+		{"concat([1], [2, 3])", "[1, 2, 3]"},
+		{"let n = 0; map(range(4), fn(x) { n += x }); n", 6},
+		{"map(1, fn(x) { x })", errorMessage("argument to `map` must be ARRAY, got INTEGER")},
+		{"map([1], fn(x) { x + true })", errorMessage("type mismatch: INTEGER + BOOLEAN")},
+		{"filter([1], fn(x, y) { true })", errorMessage("wrong number of arguments: want=2, got=1")},
+		{"sort([1, 2], fn(a, b) { a + true })", errorMessage("type mismatch: INTEGER + BOOLEAN")},
+		{"range(1, 2, 0)", errorMessage("`range` step must not be 0")},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			if evaluated.Inspect() != expected {
+				t.Errorf("wrong result for %q. want=%q, got=%q", tt.input, expected, evaluated.Inspect())
+			}
+		case errorMessage:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned for %q. got=%T(%+v)", tt.input, evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != string(expected) {
+				t.Errorf("wrong error for %q. expected=%q, got=%q", tt.input, expected, errObj.Message)
+			}
+		}
+	}
+}
+
+// errorMessage 测试表里期望得到的错误信息
+type errorMessage string
@@ -1,6 +1,8 @@
 package evaluator
 
 import (
+	"errors"
+	"fmt"
 	"monkey/ast"
 	"monkey/object"
 )
@@ -48,9 +50,55 @@ func addMacro(stmt ast.Statement, env *object.Environment) {
 	env.Set(letStetement.Name.Value, macro)
 }
 
+// ExpandProgram 逐条语句展开程序里的宏调用。宏展开失败时不会 panic,
+// 而是返回带语句位置的 "macro expansion failed" 错误,和编译器报的一样
+func ExpandProgram(program *ast.Program, env *object.Environment) (*ast.Program, error) {
+	for i, s := range program.Statements {
+		expanded, err := expandStatement(s, env)
+		if err != nil {
+			return nil, err
+		}
+		program.Statements[i] = expanded
+	}
+	return program, nil
+}
+
+func expandStatement(s ast.Statement, env *object.Environment) (ast.Statement, error) {
+	node, err := expandMacros(s, env, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: macro expansion failed: %w", s.Pos(), err)
+	}
+	expanded, ok := node.(ast.Statement)
+	if !ok {
+		return nil, fmt.Errorf("%s: macro expansion returned %T, want a statement", s.Pos(), node)
+	}
+	return expanded, nil
+}
+
 // ExpandMacors ...
+// 宏展开失败时 panic,需要错误的调用方用 ExpandProgram 或 ExpandMacrosWithLimits
 func ExpandMacros(program ast.Node, env *object.Environment) ast.Node {
-	return ast.Modify(program, func(node ast.Node) ast.Node {
+	node, err := expandMacros(program, env, nil)
+	if err != nil {
+		panic(err)
+	}
+	return node
+}
+
+// ExpandMacrosWithLimits 和 ExpandMacros 一样,但宏体的求值受 limits 限制,
+// 多次调用之间的步数和内存是累计的。宏体求值出错时返回错误,
+// 超出限制的错误可以用 errors.Is 和 ErrExecutionLimit 等比较
+func ExpandMacrosWithLimits(program ast.Node, env *object.Environment, limits *object.Limits) (ast.Node, error) {
+	return expandMacros(program, env, limits)
+}
+
+// expandMacros 遇到第一个错误后不再展开后面的宏调用
+func expandMacros(program ast.Node, env *object.Environment, limits *object.Limits) (ast.Node, error) {
+	var err error
+	node := ast.Modify(program, func(node ast.Node) ast.Node {
+		if err != nil {
+			return node
+		}
 		callExpression, ok := node.(*ast.CallExpression)
 		if !ok {
 			return node
@@ -62,13 +110,26 @@ func ExpandMacros(program ast.Node, env *object.Environment) ast.Node {
 
 		args := quoteArgs(callExpression)
 		evalEnv := extendMacroEnv(macro, args)
+		if limits != nil {
+			evalEnv.SetLimits(limits)
+		}
 		evaluated := Eval(macro.Body, evalEnv)
+		if errObj, ok := evaluated.(*object.Error); ok {
+			if errObj.Err != nil {
+				err = errObj.Err
+			} else {
+				err = errors.New(errObj.Message)
+			}
+			return node
+		}
 		quote, ok := evaluated.(*object.Quote)
 		if !ok {
-			panic("we only suppert returning AST-nodes from macros")
+			err = errors.New("we only suppert returning AST-nodes from macros")
+			return node
 		}
 		return quote.Node
 	})
+	return node, err
 }
 
 // isMacroCall ...
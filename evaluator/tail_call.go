@@ -0,0 +1,83 @@
+package evaluator
+
+import (
+	"monkey/ast"
+	"monkey/object"
+	"monkey/token"
+)
+
+// tailCall 函数体最后要做的一次调用,交给 applyFunction 在循环里执行,只会在 applyFunction 内部出现
+type tailCall struct {
+	fn   object.Object
+	args []object.Object
+	// 调用表达式的位置,被调用的函数出错时用它
+	pos token.Position
+}
+
+func (tc *tailCall) Type() object.ObjectType { return "TAIL_CALL" }
+func (tc *tailCall) Inspect() string         { return "tail call" }
+
+// evalTailPosition 和 Eval 一样求值函数体,但尾位置上的调用不马上执行,而是返回 *tailCall,
+// 这样尾递归不会让 Go 的调用栈变深。
+// 尾位置是函数体的最后一条语句,以及从那里进入的 if 分支和 return
+func evalTailPosition(node ast.Node, env *object.Environment) object.Object {
+	switch node := node.(type) {
+	case *ast.BlockStatement:
+		for i, statement := range node.Statements {
+			if i == len(node.Statements)-1 {
+				return evalTailPosition(statement, env)
+			}
+			result := Eval(statement, env)
+			if result != nil {
+				rt := result.Type()
+				if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ ||
+					rt == object.BREAK_OBJ || rt == object.CONTINUE_OBJ {
+					return result
+				}
+			}
+		}
+		return nil
+	case *ast.ExpressionStatement:
+		return evalTailPosition(node.Expression, env)
+	case *ast.ReturnStatement:
+		val := evalTailPosition(node.ReturnValue, env)
+		if _, ok := val.(*tailCall); ok || isError(val) {
+			return val
+		}
+		return &object.ReturnValue{Value: val}
+	case *ast.IfExpression:
+		condition := Eval(node.Condition, env)
+		if isError(condition) {
+			return condition
+		}
+		if isTruthy(condition) {
+			return evalTailPosition(node.Consequence, env)
+		} else if node.Alternative != nil {
+			return evalTailPosition(node.Alternative, env)
+		}
+		return NULL
+	case *ast.CallExpression:
+		if node.Function.TokenLiteral() == "quote" {
+			return Eval(node, env)
+		}
+		function := Eval(node.Function, env)
+		if isError(function) {
+			return function
+		}
+		args := evalExpressions(node.Arguments, env)
+		if len(args) == 1 && isError(args[0]) {
+			return args[0]
+		}
+		// 内置函数不会让调用栈变深,直接调用
+		if builtin, ok := function.(*object.Builtin); ok {
+			result := applyBuiltin(builtin, args, env)
+			if err, ok := result.(*object.Error); ok && !err.Pos.IsValid() {
+				err.Pos = node.Pos()
+			}
+			return result
+		}
+		return &tailCall{fn: function, args: args, pos: node.Pos()}
+	default:
+		return Eval(node, env)
+	}
+}
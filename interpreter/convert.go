@@ -0,0 +1,100 @@
+package interpreter
+
+import (
+	"fmt"
+	"monkey/object"
+	"monkey/vm"
+	"reflect"
+)
+
+// ToObject 把 Go 的值转换成 Monkey 的对象:
+// nil 是 null,布尔值、各种整数、浮点数和字符串对应同名的类型,
+// 切片和数组变成数组,map 变成哈希表,object.Object 原样返回
+func ToObject(value interface{}) (object.Object, error) {
+	switch value := value.(type) {
+	case nil:
+		return vm.Null, nil
+	case object.Object:
+		return value, nil
+	case bool:
+		if value {
+			return vm.True, nil
+		}
+		return vm.False, nil
+	case string:
+		return &object.String{Value: value}, nil
+	}
+
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &object.Integer{Value: v.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &object.Integer{Value: int64(v.Uint())}, nil
+	case reflect.Float32, reflect.Float64:
+		return &object.Float{Value: v.Float()}, nil
+	case reflect.Slice, reflect.Array:
+		elements := make([]object.Object, v.Len())
+		for i := range elements {
+			element, err := ToObject(v.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = element
+		}
+		return &object.Array{Elements: elements}, nil
+	case reflect.Map:
+		pairs := make(map[object.HashKey]object.HashPair, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := ToObject(iter.Key().Interface())
+			if err != nil {
+				return nil, err
+			}
+			hashKey, ok := key.(object.Hashable)
+			if !ok {
+				return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+			}
+			val, err := ToObject(iter.Value().Interface())
+			if err != nil {
+				return nil, err
+			}
+			pairs[hashKey.HashKey()] = object.HashPair{Key: key, Value: val}
+		}
+		return &object.Hash{Pairs: pairs}, nil
+	default:
+		return nil, fmt.Errorf("cannot convert %T to a Monkey object", value)
+	}
+}
+
+// FromObject 把 Monkey 的对象转换成 Go 的值,是 ToObject 的逆过程:
+// 整数是 int64,浮点数是 float64,数组是 []interface{},哈希表是 map[interface{}]interface{}。
+// 函数等没有对应 Go 类型的对象原样返回,可以再传给 Call
+func FromObject(obj object.Object) interface{} {
+	switch obj := obj.(type) {
+	case nil, *object.Null:
+		return nil
+	case *object.Integer:
+		return obj.Value
+	case *object.Float:
+		return obj.Value
+	case *object.Boolean:
+		return obj.Value
+	case *object.String:
+		return obj.Value
+	case *object.Array:
+		elements := make([]interface{}, len(obj.Elements))
+		for i, element := range obj.Elements {
+			elements[i] = FromObject(element)
+		}
+		return elements
+	case *object.Hash:
+		pairs := make(map[interface{}]interface{}, len(obj.Pairs))
+		for _, pair := range obj.Pairs {
+			pairs[FromObject(pair.Key)] = FromObject(pair.Value)
+		}
+		return pairs
+	default:
+		return obj
+	}
+}
@@ -0,0 +1,183 @@
+// Package interpreter 把词法分析、语法分析、编译和虚拟机串起来,
+// 给需要嵌入 Monkey 的 Go 程序用
+package interpreter
+
+import (
+	"context"
+	"fmt"
+	"monkey/compiler"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/vm"
+	"strings"
+)
+
+// Options 解释器的选项,零值表示默认的选项
+type Options struct {
+	//源代码的文件名,出现在错误的位置里
+	Filename string
+	//编译的优化级别,见 compiler.OptimizeNone 等
+	OptimizationLevel int
+	//每次 Eval 或 Call 的限制,含义和 vm.Options 里的一样。
+	//Eval 展开宏时也受同样的限制,和执行时分开计算
+	MaxSteps int
+	MaxBytes int64
+}
+
+// Interpreter 在虚拟机上执行 Monkey 代码,多次 Eval 之间保留全局变量、常量和宏。
+// 不能在多个 goroutine 里同时使用
+type Interpreter struct {
+	opts Options
+
+	builtins    *object.Registry
+	symbolTable *compiler.SymbolTable
+	constants   []object.Object
+	globals     []object.Object
+	macroEnv    *object.Environment
+}
+
+// ParseError 源代码有语法错误
+type ParseError struct {
+	Errors []string
+}
+
+func (e *ParseError) Error() string {
+	return strings.Join(e.Errors, "\n")
+}
+
+// New 创建使用默认选项的解释器
+func New() *Interpreter {
+	return NewWithOptions(Options{})
+}
+
+// NewWithOptions 按 opts 创建解释器
+func NewWithOptions(opts Options) *Interpreter {
+	builtins := object.NewRegistry()
+	symbolTable := compiler.NewSymbolTable()
+	symbolTable.DefineBuiltins(builtins)
+	return &Interpreter{
+		opts:        opts,
+		builtins:    builtins,
+		symbolTable: symbolTable,
+		constants:   []object.Object{},
+		globals:     make([]object.Object, vm.GlobalsSize),
+		macroEnv:    object.NewEnvironment(),
+	}
+}
+
+// Eval 执行一段源代码,返回最后一个表达式语句的值。
+// 语法错误返回 *ParseError,运行时错误返回 *vm.RuntimeError
+func (i *Interpreter) Eval(source string) (object.Object, error) {
+	return i.EvalContext(context.Background(), source)
+}
+
+// EvalContext 和 Eval 一样,但 ctx 被取消时停止执行并返回 vm.ErrCancelled
+func (i *Interpreter) EvalContext(ctx context.Context, source string) (object.Object, error) {
+	p := parser.New(lexer.NewWithFilename(i.opts.Filename, source))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, &ParseError{Errors: p.Errors()}
+	}
+
+	comp := compiler.NewWithState(i.symbolTable, i.constants)
+	comp.SetMacroEnv(i.macroEnv)
+	comp.SetOptimizationLevel(i.opts.OptimizationLevel)
+	comp.SetLimits(ctx, object.Limits{MaxSteps: i.opts.MaxSteps, MaxBytes: i.opts.MaxBytes})
+	if err := comp.Compile(program); err != nil {
+		return nil, err
+	}
+	bytecode := comp.Bytecode()
+	i.constants = bytecode.Constants
+
+	machine := i.newVM(bytecode)
+	if err := machine.RunContext(ctx); err != nil {
+		return nil, err
+	}
+	if result := machine.LastPoppedStackElem(); result != nil {
+		return result, nil
+	}
+	return vm.Null, nil
+}
+
+// Register 登记一个只属于这个解释器的内置函数,之后执行的代码都可以调用它。
+// arity 是参数的个数,object.Variadic 表示个数不固定
+func (i *Interpreter) Register(name string, arity int, fn object.BuiltinFunction) error {
+	if symbol, ok := i.symbolTable.Resolve(name); ok && symbol.Scope == compiler.GlobalScope {
+		return fmt.Errorf("cannot register builtin %s: already defined as a global", name)
+	}
+	if _, err := i.builtins.Register(name, arity, fn); err != nil {
+		return err
+	}
+	i.symbolTable.DefineBuiltin(len(i.builtins.Builtins())-1, name)
+	return nil
+}
+
+// Set 把 Go 的值转换后绑定到全局变量 name 上,转换规则见 ToObject
+func (i *Interpreter) Set(name string, value interface{}) error {
+	obj, err := ToObject(value)
+	if err != nil {
+		return err
+	}
+	symbol := i.symbolTable.Define(name)
+	if symbol.Index >= len(i.globals) {
+		return fmt.Errorf("too many globals: index %d exceeds limit %d", symbol.Index, len(i.globals))
+	}
+	i.globals[symbol.Index] = obj
+	return nil
+}
+
+// Get 返回全局变量 name 的值,没有定义过时第二个返回值是 false
+func (i *Interpreter) Get(name string) (object.Object, bool) {
+	symbol, ok := i.symbolTable.Resolve(name)
+	if !ok || symbol.Scope != compiler.GlobalScope {
+		return nil, false
+	}
+	obj := i.globals[symbol.Index]
+	return obj, obj != nil
+}
+
+// Call 调用全局变量 name 上的函数或者名字是 name 的内置函数,args 按 ToObject 转换
+func (i *Interpreter) Call(name string, args ...interface{}) (object.Object, error) {
+	return i.CallContext(context.Background(), name, args...)
+}
+
+// CallContext 和 Call 一样,但 ctx 被取消时停止执行并返回 vm.ErrCancelled
+func (i *Interpreter) CallContext(ctx context.Context, name string, args ...interface{}) (object.Object, error) {
+	fn, ok := i.Get(name)
+	if !ok {
+		fn, ok = i.lookupBuiltin(name)
+	}
+	if !ok {
+		return nil, fmt.Errorf("undefined function: %s", name)
+	}
+	objects := make([]object.Object, len(args))
+	for j, arg := range args {
+		obj, err := ToObject(arg)
+		if err != nil {
+			return nil, err
+		}
+		objects[j] = obj
+	}
+
+	machine := i.newVM(&compiler.Bytecode{Constants: i.constants})
+	return machine.CallContext(ctx, fn, objects...)
+}
+
+// lookupBuiltin 只查找没有被全局变量遮住的内置函数
+func (i *Interpreter) lookupBuiltin(name string) (object.Object, bool) {
+	symbol, ok := i.symbolTable.Resolve(name)
+	if !ok || symbol.Scope != compiler.BuiltinScope {
+		return nil, false
+	}
+	return i.builtins.Builtins()[symbol.Index], true
+}
+
+func (i *Interpreter) newVM(bytecode *compiler.Bytecode) *vm.VM {
+	return vm.NewWithOptions(bytecode, vm.Options{
+		Globals:  i.globals,
+		MaxSteps: i.opts.MaxSteps,
+		MaxBytes: i.opts.MaxBytes,
+		Builtins: i.builtins,
+	})
+}
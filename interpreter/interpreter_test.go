@@ -0,0 +1,344 @@
+package interpreter
+
+import (
+	"context"
+	"errors"
+	"monkey/object"
+	"monkey/vm"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"1 + 2", int64(3)},
+		{"1.5 * 2", 3.0},
+		{`"mon" + "key"`, "monkey"},
+		{"1 < 2", true},
+		{"[1, 2 * 2, \"x\"]", []interface{}{int64(1), int64(4), "x"}},
+		{`{"a": 1}`, map[interface{}]interface{}{"a": int64(1)}},
+		{"if (false) { 1 }", nil},
+		{"for (x in []) { }", nil},
+		{"for (x in [1, 2]) { x }", nil},
+		{"while (false) { }", nil},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		result, err := New().Eval(tt.input)
+		if err != nil {
+			t.Errorf("Eval(%q) failed: %s", tt.input, err)
+			continue
+		}
+		if got := FromObject(result); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("Eval(%q) wrong result. want=%#v, got=%#v", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestDeepTailRecursion(t *testing.T) {
+	input := `
+let count = fn(n, acc) { if (n == 0) { return acc; } count(n - 1, acc + 1) };
+count(100000, 0)`
+
+	result, err := New().Eval(input)
+	if err != nil {
+		t.Fatalf("Eval failed: %s", err)
+	}
+	if got := FromObject(result); got != int64(100000) {
+		t.Errorf("wrong result. want=100000, got=%#v", got)
+	}
+}
+
+func TestEvalKeepsState(t *testing.T) {
+	interp := New()
+	inputs := []string{
+		"let x = 10;",
+		"let add = fn(a) { a + x };",
+		`let double = macro(e) { quote(unquote(e) * 2) };`,
+		"add(double(1))",
+	}
+
+	var result object.Object
+	for _, input := range inputs {
+		var err error
+		result, err = interp.Eval(input)
+		if err != nil {
+			t.Fatalf("Eval(%q) failed: %s", input, err)
+		}
+	}
+	if got := FromObject(result); got != int64(12) {
+		t.Errorf("wrong result. want=12, got=%#v", got)
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	interp := NewWithOptions(Options{Filename: "rules.mk", MaxSteps: 1000})
+
+	_, err := interp.Eval("let = 1;")
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Errorf("expected *ParseError, got=%T (%v)", err, err)
+	}
+
+	_, err = interp.Eval("1 + true")
+	var runtimeErr *vm.RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("expected *vm.RuntimeError, got=%T (%v)", err, err)
+	}
+	if pos := runtimeErr.Frames[0].Pos; pos.Filename != "rules.mk" {
+		t.Errorf("wrong filename in error position. got=%q", pos.Filename)
+	}
+
+	_, err = interp.Eval("while (true) { }")
+	if !errors.Is(err, vm.ErrExecutionLimit) {
+		t.Errorf("expected vm.ErrExecutionLimit, got=%v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = New().EvalContext(ctx, "while (true) { }")
+	if !errors.Is(err, vm.ErrCancelled) {
+		t.Errorf("expected vm.ErrCancelled, got=%v", err)
+	}
+}
+
+func TestMacroExpansionLimits(t *testing.T) {
+	input := "let m = macro() { let f = fn(n) { f(n + 1) }; f(0); quote(1) }; m();"
+
+	_, err := NewWithOptions(Options{MaxSteps: 1000}).Eval(input)
+	if !errors.Is(err, vm.ErrExecutionLimit) {
+		t.Errorf("expected vm.ErrExecutionLimit, got=%v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = New().EvalContext(ctx, "let m = macro() { while (true) { }; quote(1) }; m();")
+	if !errors.Is(err, vm.ErrCancelled) {
+		t.Errorf("expected vm.ErrCancelled, got=%v", err)
+	}
+}
+
+func TestSetAndGet(t *testing.T) {
+	interp := New()
+	values := map[string]interface{}{
+		"count":  42,
+		"ratio":  0.5,
+		"name":   "monkey",
+		"active": true,
+		"tags":   []string{"a", "b"},
+		"limits": map[string]int{"max": 3},
+		"none":   nil,
+	}
+	for name, value := range values {
+		if err := interp.Set(name, value); err != nil {
+			t.Fatalf("Set(%q) failed: %s", name, err)
+		}
+	}
+
+	result, err := interp.Eval(`if (active && len(tags) == 2) { name + tags[1] + "!" }`)
+	if err != nil {
+		t.Fatalf("Eval failed: %s", err)
+	}
+	if got := FromObject(result); got != "monkeyb!" {
+		t.Errorf("wrong result. want=%q, got=%#v", "monkeyb!", got)
+	}
+
+	result, err = interp.Eval(`count * limits["max"] + ratio`)
+	if err != nil {
+		t.Fatalf("Eval failed: %s", err)
+	}
+	if got := FromObject(result); got != 126.5 {
+		t.Errorf("wrong result. want=126.5, got=%#v", got)
+	}
+
+	if _, err := interp.Eval("let count = count + 1;"); err != nil {
+		t.Fatalf("Eval failed: %s", err)
+	}
+	count, ok := interp.Get("count")
+	if !ok {
+		t.Fatalf("count is not defined")
+	}
+	if got := FromObject(count); got != int64(43) {
+		t.Errorf("wrong count. want=43, got=%#v", got)
+	}
+
+	if _, ok := interp.Get("missing"); ok {
+		t.Errorf("expected missing to be undefined")
+	}
+	if _, ok := interp.Get("len"); ok {
+		t.Errorf("expected builtins not to be returned by Get")
+	}
+	if err := interp.Set("bad", struct{}{}); err == nil {
+		t.Errorf("expected error when setting an unsupported Go value")
+	}
+}
+
+func TestCall(t *testing.T) {
+	interp := New()
+	_, err := interp.Eval(`
+let base = 100;
+let score = fn(user) { base + user["age"] * 2 };
+let countdown = fn(n) { if (n == 0) { "done" } else { countdown(n - 1) } };
+let fail = fn() { 1 + true };
+let f = len;
+`)
+	if err != nil {
+		t.Fatalf("Eval failed: %s", err)
+	}
+
+	tests := []struct {
+		name     string
+		args     []interface{}
+		expected interface{}
+	}{
+		{"score", []interface{}{map[string]interface{}{"age": 21}}, int64(142)},
+		{"countdown", []interface{}{10}, "done"},
+		{"f", []interface{}{[]int{1, 2, 3}}, int64(3)},
+	}
+	for _, tt := range tests {
+		result, err := interp.Call(tt.name, tt.args...)
+		if err != nil {
+			t.Errorf("Call(%q) failed: %s", tt.name, err)
+			continue
+		}
+		if got := FromObject(result); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("Call(%q) wrong result. want=%#v, got=%#v", tt.name, tt.expected, got)
+		}
+	}
+
+	errorTests := []struct {
+		name     string
+		args     []interface{}
+		expected string
+	}{
+		{"missing", nil, "undefined function: missing"},
+		{"score", nil, "wrong number of arguments: want=1, got=0"},
+		{"fail", nil, "unsupported types for binary operation: INTEGER BOOLEAN"},
+		{"base", nil, "calling non-non-function and non-built-in"},
+	}
+	for _, tt := range errorTests {
+		_, err := interp.Call(tt.name, tt.args...)
+		if err == nil {
+			t.Errorf("expected error for Call(%q)", tt.name)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error for Call(%q). want=%q, got=%q", tt.name, tt.expected, err)
+		}
+	}
+}
+
+func TestFromObjectKeepsFunctions(t *testing.T) {
+	interp := New()
+	fn, err := interp.Eval("fn(x) { x * 3 }")
+	if err != nil {
+		t.Fatalf("Eval failed: %s", err)
+	}
+	if FromObject(fn) != fn {
+		t.Fatalf("expected function to be returned as is")
+	}
+	if err := interp.Set("triple", FromObject(fn)); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	result, err := interp.Call("triple", 5)
+	if err != nil {
+		t.Fatalf("Call failed: %s", err)
+	}
+	if got := FromObject(result); got != int64(15) {
+		t.Errorf("wrong result. want=15, got=%#v", got)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	interp := New()
+	users := map[int64]string{1: "ana", 2: "bo"}
+	err := interp.Register("lookupUser", 1, func(caller object.Caller, args ...object.Object) object.Object {
+		id, ok := args[0].(*object.Integer)
+		if !ok {
+			return &object.Error{Message: "lookupUser: id must be INTEGER"}
+		}
+		obj, _ := ToObject(users[id.Value])
+		return obj
+	})
+	if err != nil {
+		t.Fatalf("Register failed: %s", err)
+	}
+
+	result, err := interp.Eval(`lookupUser(1) + "," + lookupUser(2)`)
+	if err != nil {
+		t.Fatalf("Eval failed: %s", err)
+	}
+	if got := FromObject(result); got != "ana,bo" {
+		t.Errorf("wrong result. want=%q, got=%#v", "ana,bo", got)
+	}
+
+	result, err = interp.Call("lookupUser", 2)
+	if err != nil {
+		t.Fatalf("Call failed: %s", err)
+	}
+	if got := FromObject(result); got != "bo" {
+		t.Errorf("wrong result. want=%q, got=%#v", "bo", got)
+	}
+	if _, ok := interp.Get("lookupUser"); ok {
+		t.Errorf("expected builtins not to be returned by Get")
+	}
+
+	if _, err := interp.Eval("let taken = 1;"); err != nil {
+		t.Fatalf("Eval failed: %s", err)
+	}
+	errorTests := []struct {
+		name     string
+		expected string
+	}{
+		{"lookupUser", "builtin lookupUser already registered"},
+		{"taken", "cannot register builtin taken: already defined as a global"},
+		{"let", `invalid builtin name: "let"`},
+	}
+	for _, tt := range errorTests {
+		err := interp.Register(tt.name, 0, func(caller object.Caller, args ...object.Object) object.Object { return nil })
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error for %q. want=%q, got=%v", tt.name, tt.expected, err)
+		}
+	}
+
+	if _, err := New().Eval("lookupUser(1)"); err == nil {
+		t.Errorf("builtin registered on one interpreter is visible in another")
+	}
+}
+
+func TestRegisterHigherOrder(t *testing.T) {
+	interp := New()
+	err := interp.Register("each", 2, func(caller object.Caller, args ...object.Object) object.Object {
+		array, ok := args[0].(*object.Array)
+		if !ok {
+			return &object.Error{Message: "each: first argument must be ARRAY"}
+		}
+		for _, element := range array.Elements {
+			if result := caller.Call(args[1], element); result.Type() == object.ERROR_OBJ {
+				return result
+			}
+		}
+		return vm.Null
+	})
+	if err != nil {
+		t.Fatalf("Register failed: %s", err)
+	}
+
+	result, err := interp.Eval("let total = 0; each([1, 2, 3], fn(x) { total += x }); total")
+	if err != nil {
+		t.Fatalf("Eval failed: %s", err)
+	}
+	if got := FromObject(result); got != int64(6) {
+		t.Errorf("wrong result. want=6, got=%#v", got)
+	}
+
+	_, err = interp.Eval("each([1], fn(x) { x + true })")
+	if err == nil || err.Error() != "unsupported types for binary operation: INTEGER BOOLEAN" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+}
@@ -1,18 +1,59 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"monkey/compiler"
 	"monkey/repl"
 	"os"
 	"os/user"
 )
 
+const usage = `usage:
+  monkey [flags]                         start the REPL
+  monkey [flags] run <file> [args...]    run a Monkey source file or a compiled .mkc file
+  monkey build <file> [-o out.mkc]       compile a source file to bytecode
+  monkey disasm <file>                   print the bytecode of a source or .mkc file
+
+flags:
+`
+
+var engine = flag.String("engine", repl.EngineVM, "use 'vm' or 'eval'")
+var optimizationLevel = flag.Int("O", compiler.OptimizePeephole, "optimization level when compiling files for the vm: 0 none, 1 constant folding and dead code, 2 also superinstructions")
+
 func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *engine != repl.EngineVM && *engine != repl.EngineEval {
+		fmt.Fprintf(os.Stderr, "unknown engine %q\n", *engine)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if flag.NArg() > 0 {
+		switch flag.Arg(0) {
+		case "run":
+			os.Exit(runCommand(*engine, flag.Args()[1:]))
+		case "build":
+			os.Exit(buildCommand(flag.Args()[1:]))
+		case "disasm":
+			os.Exit(disasmCommand(flag.Args()[1:]))
+		default:
+			fmt.Fprintf(os.Stderr, "unknown command %q\n", flag.Arg(0))
+			flag.Usage()
+			os.Exit(2)
+		}
+	}
+
 	user, err := user.Current()
 	if err != nil {
 		panic(err)
 	}
 	fmt.Printf("Hello %s! This is the Monkey programming language!\n", user.Username)
 	fmt.Printf("Feel free to type in commands\n")
-	repl.Start(os.Stdin, os.Stdout)
+	repl.Start(os.Stdin, os.Stdout, *engine)
 }
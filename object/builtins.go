@@ -9,7 +9,9 @@ var Builtins = []struct {
 	{
 		"len",
 		&Builtin{
-			Fn: func(args ...Object) Object {
+			Name:  "len",
+			Arity: 1,
+			Fn: func(caller Caller, args ...Object) Object {
 				if len(args) != 1 {
 					return newError("wrong number of arguments. got=%d, want=1", len(args))
 				}
@@ -27,7 +29,9 @@ var Builtins = []struct {
 	{
 		"puts",
 		&Builtin{
-			Fn: func(args ...Object) Object {
+			Name:  "puts",
+			Arity: Variadic,
+			Fn: func(caller Caller, args ...Object) Object {
 				for _, arg := range args {
 					fmt.Println(arg.Inspect())
 				}
@@ -38,7 +42,9 @@ var Builtins = []struct {
 	{
 		"first",
 		&Builtin{
-			Fn: func(args ...Object) Object {
+			Name:  "first",
+			Arity: 1,
+			Fn: func(caller Caller, args ...Object) Object {
 				if len(args) != 1 {
 					return newError("wrong number of arguments. got=%d, want=1", len(args))
 				}
@@ -57,7 +63,9 @@ var Builtins = []struct {
 	{
 		"last",
 		&Builtin{
-			Fn: func(args ...Object) Object {
+			Name:  "last",
+			Arity: 1,
+			Fn: func(caller Caller, args ...Object) Object {
 				if len(args) != 1 {
 					return newError("wrong number of arguments. got=%d, want=1", len(args))
 				}
@@ -77,7 +85,9 @@ var Builtins = []struct {
 	{
 		"rest",
 		&Builtin{
-			Fn: func(args ...Object) Object {
+			Name:  "rest",
+			Arity: 1,
+			Fn: func(caller Caller, args ...Object) Object {
 				if len(args) != 1 {
 					return newError("wrong number of arguments. got=%d, want=1", len(args))
 				}
@@ -100,7 +110,9 @@ var Builtins = []struct {
 	{
 		"push",
 		&Builtin{
-			Fn: func(args ...Object) Object {
+			Name:  "push",
+			Arity: 2,
+			Fn: func(caller Caller, args ...Object) Object {
 				if len(args) != 2 {
 					return newError("wrong number of arguments. got=%d, want=2", len(args))
 				}
@@ -116,6 +128,46 @@ var Builtins = []struct {
 			},
 		},
 	},
+	{
+		"map",
+		&Builtin{Name: "map", Arity: 2, Fn: builtinMap},
+	},
+	{
+		"filter",
+		&Builtin{Name: "filter", Arity: 2, Fn: builtinFilter},
+	},
+	{
+		"reduce",
+		&Builtin{Name: "reduce", Arity: 3, Fn: builtinReduce},
+	},
+	{
+		"sort",
+		&Builtin{Name: "sort", Arity: Variadic, Fn: builtinSort},
+	},
+	{
+		"range",
+		&Builtin{Name: "range", Arity: Variadic, Fn: builtinRange},
+	},
+	{
+		"reverse",
+		&Builtin{Name: "reverse", Arity: 1, Fn: builtinReverse},
+	},
+	{
+		"contains",
+		&Builtin{Name: "contains", Arity: 2, Fn: builtinContains},
+	},
+	{
+		"index_of",
+		&Builtin{Name: "index_of", Arity: 2, Fn: builtinIndexOf},
+	},
+	{
+		"slice",
+		&Builtin{Name: "slice", Arity: Variadic, Fn: builtinSlice},
+	},
+	{
+		"concat",
+		&Builtin{Name: "concat", Arity: Variadic, Fn: builtinConcat},
+	},
 }
 
 func newError(format string, a ...interface{}) *Error {
@@ -0,0 +1,413 @@
+package object
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// 数组相关的内置函数。接受回调的函数通过 caller 调用 Monkey 的函数,
+// 回调出错时原样返回它的错误
+
+func builtinMap(caller Caller, args ...Object) Object {
+	if len(args) != 2 {
+		return newError("wrong number of arguments. got=%d, want=2", len(args))
+	}
+	arr, ok := args[0].(*Array)
+	if !ok {
+		return newError("argument to `map` must be ARRAY, got %s", args[0].Type())
+	}
+	if err := caller.Allocate(ArraySize(int64(len(arr.Elements)))); err != nil {
+		return WrapError(err)
+	}
+	elements := make([]Object, len(arr.Elements))
+	for i, element := range arr.Elements {
+		result := caller.Call(args[1], element)
+		if isError(result) {
+			return result
+		}
+		elements[i] = result
+	}
+	return &Array{Elements: elements}
+}
+
+func builtinFilter(caller Caller, args ...Object) Object {
+	if len(args) != 2 {
+		return newError("wrong number of arguments. got=%d, want=2", len(args))
+	}
+	arr, ok := args[0].(*Array)
+	if !ok {
+		return newError("argument to `filter` must be ARRAY, got %s", args[0].Type())
+	}
+	elements := []Object{}
+	for _, element := range arr.Elements {
+		result := caller.Call(args[1], element)
+		if isError(result) {
+			return result
+		}
+		if isTruthy(result) {
+			elements = append(elements, element)
+		}
+	}
+	return &Array{Elements: elements}
+}
+
+// builtinReduce reduce(arr, f, initial) 从 initial 开始依次计算 f(acc, element)
+func builtinReduce(caller Caller, args ...Object) Object {
+	if len(args) != 3 {
+		return newError("wrong number of arguments. got=%d, want=3", len(args))
+	}
+	arr, ok := args[0].(*Array)
+	if !ok {
+		return newError("argument to `reduce` must be ARRAY, got %s", args[0].Type())
+	}
+	acc := args[2]
+	for _, element := range arr.Elements {
+		acc = caller.Call(args[1], acc, element)
+		if isError(acc) {
+			return acc
+		}
+	}
+	return acc
+}
+
+// builtinSort sort(arr) 把全是数字或全是字符串的数组按升序排列,
+// sort(arr, less) 按 less(a, b) 为真表示 a 排在 b 前面来排列。
+// 返回新的数组,排序是稳定的
+func builtinSort(caller Caller, args ...Object) Object {
+	if len(args) != 1 && len(args) != 2 {
+		return newError("wrong number of arguments. got=%d, want=1 or 2", len(args))
+	}
+	arr, ok := args[0].(*Array)
+	if !ok {
+		return newError("argument to `sort` must be ARRAY, got %s", args[0].Type())
+	}
+	if err := caller.Allocate(ArraySize(int64(len(arr.Elements)))); err != nil {
+		return WrapError(err)
+	}
+	elements := make([]Object, len(arr.Elements))
+	copy(elements, arr.Elements)
+
+	if len(args) == 1 {
+		less, err := naturalOrder(elements)
+		if err != nil {
+			return err
+		}
+		sort.SliceStable(elements, less)
+		return &Array{Elements: elements}
+	}
+
+	var err Object
+	sort.SliceStable(elements, func(i, j int) bool {
+		if err != nil {
+			return false
+		}
+		result := caller.Call(args[1], elements[i], elements[j])
+		if isError(result) {
+			err = result
+			return false
+		}
+		return isTruthy(result)
+	})
+	if err != nil {
+		return err
+	}
+	return &Array{Elements: elements}
+}
+
+// naturalOrder 没有比较函数时的顺序:数字按大小,字符串按字典序,不能混在一起
+func naturalOrder(elements []Object) (func(i, j int) bool, *Error) {
+	numbers, strs := true, true
+	for _, element := range elements {
+		switch element.(type) {
+		case *Integer, *Float:
+			strs = false
+		case *String:
+			numbers = false
+		default:
+			numbers, strs = false, false
+		}
+	}
+	switch {
+	case numbers:
+		return func(i, j int) bool {
+			return toFloat(elements[i]) < toFloat(elements[j])
+		}, nil
+	case strs:
+		return func(i, j int) bool {
+			return elements[i].(*String).Value < elements[j].(*String).Value
+		}, nil
+	default:
+		return nil, newError("argument to `sort` must be ARRAY of numbers or strings without a comparator")
+	}
+}
+
+// builtinRange range(end)、range(start, end) 或 range(start, end, step),和 Python 的 range 一样不包括 end
+func builtinRange(caller Caller, args ...Object) Object {
+	if len(args) < 1 || len(args) > 3 {
+		return newError("wrong number of arguments. got=%d, want=1 to 3", len(args))
+	}
+	bounds := make([]int64, len(args))
+	for i, arg := range args {
+		integer, ok := arg.(*Integer)
+		if !ok {
+			return newError("arguments to `range` must be INTEGER, got %s", arg.Type())
+		}
+		bounds[i] = integer.Value
+	}
+
+	start, end, step := int64(0), bounds[0], int64(1)
+	if len(bounds) > 1 {
+		start, end = bounds[0], bounds[1]
+	}
+	if len(bounds) > 2 {
+		step = bounds[2]
+	}
+	if step == 0 {
+		return newError("`range` step must not be 0")
+	}
+
+	// 先按元素的个数登记整个数组,超出内存限制时一个元素都不用生成
+	if err := caller.Allocate(rangeSize(start, end, step)); err != nil {
+		return WrapError(err)
+	}
+	elements := []Object{}
+	for i := start; (step > 0 && i < end) || (step < 0 && i > end); i += step {
+		// 每个元素算一步,这样步数限制和取消也能打断很长的 range
+		if err := caller.Allocate(0); err != nil {
+			return WrapError(err)
+		}
+		elements = append(elements, &Integer{Value: i})
+		// 下一个数会溢出时已经到头了
+		if (step > 0 && i > math.MaxInt64-step) || (step < 0 && i < math.MinInt64-step) {
+			break
+		}
+	}
+	return &Array{Elements: elements}
+}
+
+// rangeSize range 生成的数组的估算大小,元素太多时是 math.MaxInt64
+func rangeSize(start, end, step int64) int64 {
+	var count uint64
+	switch {
+	case step > 0 && start < end:
+		count = (uint64(end-start)-1)/uint64(step) + 1
+	case step < 0 && start > end:
+		count = (uint64(start-end)-1)/(uint64(-(step+1))+1) + 1
+	}
+	if count > uint64(math.MaxInt64-ArraySize(0))/uint64(ArraySize(1)-ArraySize(0)) {
+		return math.MaxInt64
+	}
+	return ArraySize(int64(count))
+}
+
+func builtinReverse(caller Caller, args ...Object) Object {
+	if len(args) != 1 {
+		return newError("wrong number of arguments. got=%d, want=1", len(args))
+	}
+	arr, ok := args[0].(*Array)
+	if !ok {
+		return newError("argument to `reverse` must be ARRAY, got %s", args[0].Type())
+	}
+	length := len(arr.Elements)
+	if err := caller.Allocate(ArraySize(int64(length))); err != nil {
+		return WrapError(err)
+	}
+	elements := make([]Object, length)
+	for i, element := range arr.Elements {
+		elements[length-1-i] = element
+	}
+	return &Array{Elements: elements}
+}
+
+// builtinContains 数组里有没有等于 x 的元素,字符串里有没有子串 x,哈希表里有没有键 x
+func builtinContains(caller Caller, args ...Object) Object {
+	if len(args) != 2 {
+		return newError("wrong number of arguments. got=%d, want=2", len(args))
+	}
+	switch collection := args[0].(type) {
+	case *Array:
+		return nativeBoolToBooleanObject(indexOf(collection, args[1]) >= 0)
+	case *String:
+		sub, ok := args[1].(*String)
+		if !ok {
+			return newError("second argument to `contains` must be STRING, got %s", args[1].Type())
+		}
+		return nativeBoolToBooleanObject(strings.Contains(collection.Value, sub.Value))
+	case *Hash:
+		key, ok := args[1].(Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", args[1].Type())
+		}
+		_, ok = collection.Pairs[key.HashKey()]
+		return nativeBoolToBooleanObject(ok)
+	default:
+		return newError("argument to `contains` not supported, got %s", args[0].Type())
+	}
+}
+
+// builtinIndexOf 元素或子串第一次出现的下标,找不到时是 -1。字符串的下标按字节计算,和 len 一样
+func builtinIndexOf(caller Caller, args ...Object) Object {
+	if len(args) != 2 {
+		return newError("wrong number of arguments. got=%d, want=2", len(args))
+	}
+	switch collection := args[0].(type) {
+	case *Array:
+		return &Integer{Value: int64(indexOf(collection, args[1]))}
+	case *String:
+		sub, ok := args[1].(*String)
+		if !ok {
+			return newError("second argument to `index_of` must be STRING, got %s", args[1].Type())
+		}
+		return &Integer{Value: int64(strings.Index(collection.Value, sub.Value))}
+	default:
+		return newError("argument to `index_of` not supported, got %s", args[0].Type())
+	}
+}
+
+// builtinSlice slice(x, start) 或 slice(x, start, end),x 是数组或字符串。
+// 负数的下标从末尾往前数,超出范围的下标会被截到范围里
+func builtinSlice(caller Caller, args ...Object) Object {
+	if len(args) != 2 && len(args) != 3 {
+		return newError("wrong number of arguments. got=%d, want=2 or 3", len(args))
+	}
+	var length int
+	switch collection := args[0].(type) {
+	case *Array:
+		length = len(collection.Elements)
+	case *String:
+		length = len(collection.Value)
+	default:
+		return newError("argument to `slice` not supported, got %s", args[0].Type())
+	}
+
+	bounds := []int{0, length}
+	for i, arg := range args[1:] {
+		integer, ok := arg.(*Integer)
+		if !ok {
+			return newError("indices to `slice` must be INTEGER, got %s", arg.Type())
+		}
+		bounds[i] = clampIndex(integer.Value, length)
+	}
+	start, end := bounds[0], bounds[1]
+	if end < start {
+		end = start
+	}
+
+	size := StringSize(int64(end - start))
+	if _, ok := args[0].(*Array); ok {
+		size = ArraySize(int64(end - start))
+	}
+	if err := caller.Allocate(size); err != nil {
+		return WrapError(err)
+	}
+
+	switch collection := args[0].(type) {
+	case *Array:
+		elements := make([]Object, end-start)
+		copy(elements, collection.Elements[start:end])
+		return &Array{Elements: elements}
+	default:
+		return &String{Value: collection.(*String).Value[start:end]}
+	}
+}
+
+func clampIndex(index int64, length int) int {
+	if index < 0 {
+		index += int64(length)
+	}
+	if index < 0 {
+		return 0
+	}
+	if index > int64(length) {
+		return length
+	}
+	return int(index)
+}
+
+// builtinConcat 把若干个数组按顺序拼成一个新数组
+func builtinConcat(caller Caller, args ...Object) Object {
+	length := 0
+	for _, arg := range args {
+		arr, ok := arg.(*Array)
+		if !ok {
+			return newError("arguments to `concat` must be ARRAY, got %s", arg.Type())
+		}
+		length += len(arr.Elements)
+	}
+	if err := caller.Allocate(ArraySize(int64(length))); err != nil {
+		return WrapError(err)
+	}
+	elements := make([]Object, 0, length)
+	for _, arg := range args {
+		elements = append(elements, arg.(*Array).Elements...)
+	}
+	return &Array{Elements: elements}
+}
+
+func indexOf(arr *Array, target Object) int {
+	for i, element := range arr.Elements {
+		if equals(element, target) {
+			return i
+		}
+	}
+	return -1
+}
+
+// equals contains 和 index_of 用的相等:数字、字符串和布尔值按值比较,其他对象按是否是同一个对象比较
+func equals(a, b Object) bool {
+	switch a := a.(type) {
+	case *Integer:
+		if b, ok := b.(*Integer); ok {
+			return a.Value == b.Value
+		}
+	case *String:
+		if b, ok := b.(*String); ok {
+			return a.Value == b.Value
+		}
+	case *Boolean:
+		if b, ok := b.(*Boolean); ok {
+			return a.Value == b.Value
+		}
+	case *Null:
+		_, ok := b.(*Null)
+		return ok
+	}
+	if isNumber(a) && isNumber(b) {
+		return toFloat(a) == toFloat(b)
+	}
+	return a == b
+}
+
+func isNumber(obj Object) bool {
+	return obj.Type() == INTEGER_OBJ || obj.Type() == FLOAT_OBJ
+}
+
+func toFloat(obj Object) float64 {
+	if integer, ok := obj.(*Integer); ok {
+		return float64(integer.Value)
+	}
+	return obj.(*Float).Value
+}
+
+func isTruthy(obj Object) bool {
+	switch obj := obj.(type) {
+	case *Boolean:
+		return obj.Value
+	case *Null:
+		return false
+	default:
+		return true
+	}
+}
+
+func isError(obj Object) bool {
+	return obj != nil && obj.Type() == ERROR_OBJ
+}
+
+func nativeBoolToBooleanObject(input bool) *Boolean {
+	if input {
+		return TRUE
+	}
+	return FALSE
+}
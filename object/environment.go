@@ -1,5 +1,10 @@
 package object
 
+import (
+	"errors"
+	"sort"
+)
+
 func NewEnclosedEnvironment(outer *Environment) *Environment {
 	env := NewEnvironment()
 	env.outer = outer
@@ -14,6 +19,62 @@ func NewEnvironment() *Environment {
 type Environment struct {
 	store map[string]Object
 	outer *Environment
+
+	//求值的限制,一般只设置在最外层的环境上
+	limits *Limits
+	//可以使用的内置函数,和 limits 一样只设置在最外层的环境上
+	builtins *Registry
+}
+
+// 执行被限制打断时的错误,虚拟机和展开宏的编译器都会返回它们,可以用 errors.Is 区分
+var (
+	ErrExecutionLimit = errors.New("execution limit exceeded")
+	ErrCancelled      = errors.New("cancelled")
+	ErrMemoryLimit    = errors.New("memory limit exceeded")
+)
+
+// Limits 求值器的执行限制,由 evaluator.EvalContext 设置
+type Limits struct {
+	//被取消时停止求值,nil 表示不会被取消
+	Done <-chan struct{}
+	//最多求值的步数,0 表示不限制
+	MaxSteps int
+	//已经求值的步数
+	Steps int
+	//新建的数组、字符串和哈希表最多占用的字节数,按 SizeOf 估算,0 表示不限制
+	MaxBytes int64
+	//已经分配的字节数
+	Bytes int64
+}
+
+// SetLimits 设置这个环境以及嵌套在它里面的环境的执行限制,nil 表示取消限制
+func (e *Environment) SetLimits(limits *Limits) {
+	e.limits = limits
+}
+
+// Limits 从当前环境往外找到的第一个执行限制,没有限制时是 nil
+func (e *Environment) Limits() *Limits {
+	for env := e; env != nil; env = env.outer {
+		if env.limits != nil {
+			return env.limits
+		}
+	}
+	return nil
+}
+
+// SetBuiltins 设置这个环境以及嵌套在它里面的环境可以使用的内置函数,nil 表示默认的内置函数
+func (e *Environment) SetBuiltins(builtins *Registry) {
+	e.builtins = builtins
+}
+
+// Builtins 从当前环境往外找到的第一个 Registry,没有设置过时是 nil
+func (e *Environment) Builtins() *Registry {
+	for env := e; env != nil; env = env.outer {
+		if env.builtins != nil {
+			return env.builtins
+		}
+	}
+	return nil
 }
 
 // Get ...
@@ -30,3 +91,25 @@ func (e *Environment) Set(name string, val Object) Object {
 	e.store[name] = val
 	return val
 }
+
+// Assign 修改最近一层环境里已有的绑定,找不到这个名字时返回 false
+func (e *Environment) Assign(name string, val Object) bool {
+	if _, ok := e.store[name]; ok {
+		e.store[name] = val
+		return true
+	}
+	if e.outer != nil {
+		return e.outer.Assign(name, val)
+	}
+	return false
+}
+
+// Names 按字母顺序返回当前环境(不包括外层环境)中绑定的名字
+func (e *Environment) Names() []string {
+	names := make([]string, 0, len(e.store))
+	for name := range e.store {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -4,8 +4,12 @@ import (
 	"bytes"
 	"fmt"
 	"hash/fnv"
+	"math"
 	"monkey/ast"
 	"monkey/code"
+	"monkey/token"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -13,9 +17,12 @@ type ObjectType string
 
 const (
 	INTEGER_OBJ      = "INTEGER"
+	FLOAT_OBJ        = "FLOAT"
 	BOOLEAN_OBJ      = "BOOLEAN"
 	NULL_OBJ         = "NULL"
 	RETURN_VALUE_OBJ = "RETURN_VALUE"
+	BREAK_OBJ        = "BREAK"
+	CONTINUE_OBJ     = "CONTINUE"
 	ERROR_OBJ        = "ERROR"
 	FUNCTION_OBJ     = "FUNCTION"
 	STRING_OBJ       = "STRING"
@@ -62,6 +69,39 @@ func (i *Integer) HashKey() HashKey {
 	return *i.HashValue
 }
 
+type Float struct {
+	Value     float64
+	HashValue *HashKey
+}
+
+// Inspect 整数值的浮点数也带上小数点,避免和整数混淆
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+
+// Type ...
+func (f *Float) Type() ObjectType {
+	return FLOAT_OBJ
+}
+
+// HashKey 整数值的浮点数和对应的整数相等,哈希值也和整数的相同,所以 {1: "a"}[1.0] 能找到
+func (f *Float) HashKey() HashKey {
+	if f.HashValue == nil {
+		value := f.Value
+		if value == math.Trunc(value) && value >= math.MinInt64 && value < math.MaxInt64 {
+			// -0.0 也会变成整数 0
+			f.HashValue = &HashKey{Type: INTEGER_OBJ, Value: uint64(int64(value))}
+		} else {
+			f.HashValue = &HashKey{Type: f.Type(), Value: math.Float64bits(value)}
+		}
+	}
+	return *f.HashValue
+}
+
 type Boolean struct {
 	Value     bool
 	HashValue *HashKey
@@ -93,6 +133,13 @@ func (b *Boolean) HashKey() HashKey {
 
 type Null struct{}
 
+// 求值器和虚拟机共用的单例,两边都按指针比较布尔值,内置函数要返回这几个对象
+var (
+	NULL  = &Null{}
+	TRUE  = &Boolean{Value: true}
+	FALSE = &Boolean{Value: false}
+)
+
 // Inspect ...
 func (n *Null) Inspect() string  { return "null" }
 func (n *Null) Type() ObjectType { return NULL_OBJ }
@@ -104,15 +151,38 @@ type ReturnValue struct {
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 
+// Break 和 Continue 是求值器里 break/continue 语句的结果,会一直向外传递到最近的循环
+type Break struct{}
+
+func (b *Break) Inspect() string  { return "break" }
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+
+type Continue struct{}
+
+func (c *Continue) Inspect() string  { return "continue" }
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+
 type Error struct {
 	Message string
+	Pos     token.Position //出错的节点所在的位置
+	Err     error          //执行被限制打断时是 ErrExecutionLimit 等,可以用 errors.Is 比较
+}
+
+// WrapError 把 Go 的错误包装成错误对象,Err 保留原来的错误
+func WrapError(err error) *Error {
+	return &Error{Message: err.Error(), Err: err}
 }
 
 // Type ...
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
 
 // Inspect ...
-func (e *Error) Inspect() string { return "ERROR: " + e.Message }
+func (e *Error) Inspect() string {
+	if e.Pos.IsValid() {
+		return "ERROR: " + e.Pos.String() + ": " + e.Message
+	}
+	return "ERROR: " + e.Message
+}
 
 type Function struct {
 	Parameters []*ast.Identifier
@@ -169,11 +239,25 @@ func (s *String) HashKey() HashKey {
 	return *s.HashValue
 }
 
-// 函数需要接受零个或多个object.Object作为参数并能返回一个object.Object
-type BuiltinFunction func(args ...Object) Object
+// 函数需要接受零个或多个object.Object作为参数并能返回一个object.Object。
+// caller 用来调用作为参数传进来的 Monkey 函数
+type BuiltinFunction func(caller Caller, args ...Object) Object
+
+// Caller 让内置函数调用 Monkey 的函数和内置函数,虚拟机和求值器各有自己的实现
+type Caller interface {
+	// Call 调用 fn 并返回结果,出错时返回 *Error,内置函数应该原样返回这个错误
+	Call(fn Object, args ...Object) Object
+	// Allocate 在生成结果之前登记要新分配的 size 个字节(按 SizeOf 估算),同时算执行了一步。
+	// 超出内存或步数的限制、或者被取消时返回错误,内置函数应该停下来把它作为 *Error 返回。
+	// 结果里已经登记过的字节不会再重复计算
+	Allocate(size int64) error
+}
 
 type Builtin struct {
-	Fn BuiltinFunction
+	Name string
+	//参数的个数,Variadic 表示个数不固定
+	Arity int
+	Fn    BuiltinFunction
 }
 
 func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
@@ -236,6 +320,35 @@ func (h *Hash) Inspect() string {
 	return out.String()
 }
 
+// Keys 返回排好序的所有键,for-in 循环按这个顺序遍历哈希表
+func (h *Hash) Keys() []Object {
+	keys := make([]Object, 0, len(h.Pairs))
+	for _, pair := range h.Pairs {
+		keys = append(keys, pair.Key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return lessKey(keys[i], keys[j])
+	})
+	return keys
+}
+
+// lessKey 同类型的键按值比较,不同类型的键按类型名比较
+func lessKey(a, b Object) bool {
+	if a.Type() != b.Type() {
+		return a.Type() < b.Type()
+	}
+	switch a := a.(type) {
+	case *Integer:
+		return a.Value < b.(*Integer).Value
+	case *Float:
+		return a.Value < b.(*Float).Value
+	case *Boolean:
+		return !a.Value && b.(*Boolean).Value
+	default:
+		return a.Inspect() < b.Inspect()
+	}
+}
+
 type Quote struct {
 	Node ast.Node
 }
@@ -279,6 +392,10 @@ type CompiledFunction struct {
 	Instructions  code.Instructions
 	NumLocals     int
 	NumParameters int
+	//let 绑定的函数名,匿名函数为空
+	Name string
+	//指令偏移量到源码范围的映射,运行时错误的调用栈会用到
+	SourceMap code.SourceMap
 }
 
 func (cf *CompiledFunction) Type() ObjectType {
@@ -20,3 +20,129 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("stings with different content have same hash keys")
 	}
 }
+
+func TestFloatHashKey(t *testing.T) {
+	one1 := &Float{Value: 1.5}
+	one2 := &Float{Value: 1.5}
+	zero := &Float{Value: 0}
+	negZero := &Float{Value: -zero.Value}
+	integer := &Integer{Value: 1}
+
+	if one1.HashKey() != one2.HashKey() {
+		t.Errorf("floats with same value have different hash keys")
+	}
+
+	if zero.HashKey() != negZero.HashKey() {
+		t.Errorf("0.0 and -0.0 have different hash keys")
+	}
+
+	if one1.HashKey() == integer.HashKey() {
+		t.Errorf("float and integer have same hash keys")
+	}
+
+	if (&Float{Value: 1}).HashKey() != integer.HashKey() {
+		t.Errorf("1.0 and 1 have different hash keys")
+	}
+
+	if (&Float{Value: -3}).HashKey() != (&Integer{Value: -3}).HashKey() {
+		t.Errorf("-3.0 and -3 have different hash keys")
+	}
+}
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		input    float64
+		expected string
+	}{
+		{3.14, "3.14"},
+		{2, "2.0"},
+		{-0.5, "-0.5"},
+		{1e21, "1e+21"},
+	}
+
+	for _, tt := range tests {
+		f := &Float{Value: tt.input}
+		if f.Inspect() != tt.expected {
+			t.Errorf("wrong Inspect for %g. want=%q, got=%q", tt.input, tt.expected, f.Inspect())
+		}
+	}
+}
+
+func TestSizeOf(t *testing.T) {
+	tests := []struct {
+		input    Object
+		expected int64
+	}{
+		{&String{Value: "hello"}, 37},
+		{&Array{Elements: []Object{&Integer{Value: 1}, &Integer{Value: 2}}}, 64},
+		{&Hash{Pairs: map[HashKey]HashPair{{Type: INTEGER_OBJ, Value: 1}: {}}}, 112},
+		{&Integer{Value: 1}, 0},
+	}
+
+	for _, tt := range tests {
+		if got := SizeOf(tt.input); got != tt.expected {
+			t.Errorf("wrong size for %s. want=%d, got=%d", tt.input.Inspect(), tt.expected, got)
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	if len(r.Builtins()) != len(Builtins) {
+		t.Fatalf("wrong number of default builtins. want=%d, got=%d", len(Builtins), len(r.Builtins()))
+	}
+	for i, def := range Builtins {
+		if r.Builtins()[i] != def.Builtin {
+			t.Errorf("default builtin %s at wrong index %d", def.Name, i)
+		}
+	}
+
+	double := func(caller Caller, args ...Object) Object {
+		return &Integer{Value: args[0].(*Integer).Value * 2}
+	}
+	builtin, err := r.Register("double", 1, double)
+	if err != nil {
+		t.Fatalf("Register failed: %s", err)
+	}
+	if builtin.Name != "double" || builtin.Arity != 1 {
+		t.Errorf("wrong builtin metadata. got=%+v", builtin)
+	}
+	if found, ok := r.Lookup("double"); !ok || found != builtin {
+		t.Errorf("Lookup did not return the registered builtin")
+	}
+	if r.Builtins()[len(Builtins)] != builtin {
+		t.Errorf("registered builtin not appended after the defaults")
+	}
+
+	result := builtin.Fn(nil, &Integer{Value: 21})
+	if integer, ok := result.(*Integer); !ok || integer.Value != 42 {
+		t.Errorf("wrong result. got=%+v", result)
+	}
+	result = builtin.Fn(nil)
+	if errObj, ok := result.(*Error); !ok || errObj.Message != "wrong number of arguments. got=0, want=1" {
+		t.Errorf("wrong arity error. got=%+v", result)
+	}
+
+	errorTests := []struct {
+		name     string
+		arity    int
+		expected string
+	}{
+		{"double", 1, "builtin double already registered"},
+		{"len", 1, "builtin len already registered"},
+		{"fn", 0, `invalid builtin name: "fn"`},
+		{"lookup_2", 0, `invalid builtin name: "lookup_2"`},
+		{"", 0, `invalid builtin name: ""`},
+		{"negative", -2, "invalid arity for builtin negative: -2"},
+	}
+	for _, tt := range errorTests {
+		_, err := r.Register(tt.name, tt.arity, double)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error for %q. want=%q, got=%v", tt.name, tt.expected, err)
+		}
+	}
+
+	if _, ok := NewRegistry().Lookup("double"); ok {
+		t.Errorf("registries are not independent")
+	}
+}
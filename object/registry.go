@@ -0,0 +1,90 @@
+package object
+
+import (
+	"fmt"
+	"monkey/token"
+)
+
+// Variadic 参数个数不固定的内置函数的 Arity
+const Variadic = -1
+
+// MaxBuiltins OpGetBuiltin 的操作数只有一个字节,最多能有这么多内置函数
+const MaxBuiltins = 256
+
+// Registry 按名字登记的一组内置函数,登记的顺序就是 OpGetBuiltin 用的下标。
+// 每个解释器可以有自己的 Registry,宿主程序往里面登记自己的函数
+type Registry struct {
+	builtins []*Builtin
+	index    map[string]int
+}
+
+// NewRegistry 创建一个已经登记了 Builtins 里所有默认内置函数的 Registry
+func NewRegistry() *Registry {
+	r := &Registry{index: map[string]int{}}
+	for _, def := range Builtins {
+		r.add(def.Builtin)
+	}
+	return r
+}
+
+// Register 登记一个内置函数。arity 不是 Variadic 时,
+// 调用时参数个数不对会返回和默认内置函数一样的 "wrong number of arguments" 错误
+func (r *Registry) Register(name string, arity int, fn BuiltinFunction) (*Builtin, error) {
+	if !isIdentifier(name) {
+		return nil, fmt.Errorf("invalid builtin name: %q", name)
+	}
+	if _, ok := r.index[name]; ok {
+		return nil, fmt.Errorf("builtin %s already registered", name)
+	}
+	if len(r.builtins) >= MaxBuiltins {
+		return nil, fmt.Errorf("too many builtins: limit is %d", MaxBuiltins)
+	}
+	if arity < Variadic {
+		return nil, fmt.Errorf("invalid arity for builtin %s: %d", name, arity)
+	}
+
+	call := fn
+	if arity != Variadic {
+		call = func(caller Caller, args ...Object) Object {
+			if len(args) != arity {
+				return newError("wrong number of arguments. got=%d, want=%d", len(args), arity)
+			}
+			return fn(caller, args...)
+		}
+	}
+	builtin := &Builtin{Name: name, Arity: arity, Fn: call}
+	r.add(builtin)
+	return builtin, nil
+}
+
+func (r *Registry) add(builtin *Builtin) {
+	r.index[builtin.Name] = len(r.builtins)
+	r.builtins = append(r.builtins, builtin)
+}
+
+// Lookup 按名字查找内置函数
+func (r *Registry) Lookup(name string) (*Builtin, bool) {
+	i, ok := r.index[name]
+	if !ok {
+		return nil, false
+	}
+	return r.builtins[i], true
+}
+
+// Builtins 按登记的顺序返回所有内置函数,下标和 OpGetBuiltin 的操作数对应
+func (r *Registry) Builtins() []*Builtin {
+	return r.builtins
+}
+
+// isIdentifier 名字要能在 Monkey 代码里写出来:只有字母和下划线,并且不是关键字
+func isIdentifier(name string) bool {
+	if name == "" || token.LookupIdent(name) != token.IDENT {
+		return false
+	}
+	for _, ch := range name {
+		if !('a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,37 @@
+package object
+
+// 估算内存用的近似大小,按 64 位平台计算
+const (
+	stringHeaderSize = 32
+	arrayHeaderSize  = 32
+	hashHeaderSize   = 48
+	//数组里的一个元素是一个接口值
+	elementSize = 16
+	//哈希表里的一个键值对,包括 HashKey、两个接口值和 map 自身的开销
+	HashPairSize = 64
+)
+
+// SizeOf 估算数组、字符串和哈希表新分配的字节数,其他对象返回 0。
+// 数组和哈希表只算自身,不算里面的元素,元素在创建时已经算过
+func SizeOf(obj Object) int64 {
+	switch obj := obj.(type) {
+	case *String:
+		return StringSize(int64(len(obj.Value)))
+	case *Array:
+		return ArraySize(int64(len(obj.Elements)))
+	case *Hash:
+		return hashHeaderSize + HashPairSize*int64(len(obj.Pairs))
+	default:
+		return 0
+	}
+}
+
+// ArraySize 估算有 length 个元素的新数组的字节数,和 SizeOf 对数组的估算一样
+func ArraySize(length int64) int64 {
+	return arrayHeaderSize + elementSize*length
+}
+
+// StringSize 估算长度是 length 个字节的新字符串的字节数
+func StringSize(length int64) int64 {
+	return stringHeaderSize + length
+}
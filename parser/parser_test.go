@@ -244,6 +244,38 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.14;", 3.14},
+		{"1e-9;", 1e-9},
+		{"2.5E+3;", 2500},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if len(program.Statements) != 1 {
+			t.Fatalf("program has not enough statements.got=%d", len(program.Statements))
+		}
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T", program.Statements[0])
+		}
+		literal, ok := stmt.Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.FloatLiteral. got=%T", stmt.Expression)
+		}
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value not %g. got=%g", tt.expected, literal.Value)
+		}
+	}
+}
+
 func TestBoolenExpression(t *testing.T) {
 	input := `
 true;
@@ -391,6 +423,30 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"a + b + c",
 			"((a + b) + c)",
 		},
+		{
+			"a % b * c",
+			"((a % b) * c)",
+		},
+		{
+			"a + b % c",
+			"(a + (b % c))",
+		},
+		{
+			"a <= b == b >= a",
+			"((a <= b) == (b >= a))",
+		},
+		{
+			"a || b && c",
+			"(a || (b && c))",
+		},
+		{
+			"a && b || c && d",
+			"((a && b) || (c && d))",
+		},
+		{
+			"a == b && c < d || !e",
+			"(((a == b) && (c < d)) || (!e))",
+		},
 		{
 			"a + b - c",
 			"((a + b) - c)",
@@ -887,3 +943,275 @@ func TestFunctionLiteralWithName(t *testing.T) {
             function.Name)
     }
 }
+
+func TestParserErrorPosition(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"let x 5;", "main.mk:1:7: expected next token to be =, got INT instead"},
+		{"let x = 1;\n  let = 2;", "main.mk:2:7: expected next token to be IDENT, got = instead"},
+		{"1 +\n\n  ;", "main.mk:3:3: no prefix parse function for ; found"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.NewWithFilename("main.mk", tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Fatalf("expected parser errors for %q, got none", tt.input)
+		}
+		if errors[0] != tt.expectedError {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expectedError, errors[0])
+		}
+	}
+}
+
+func TestNodePositions(t *testing.T) {
+	input := `let add = fn(a, b) {
+  a + b
+};`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.LetStatement)
+	function := stmt.Value.(*ast.FunctionLiteral)
+	body := function.Body.Statements[0].(*ast.ExpressionStatement)
+	infix := body.Expression.(*ast.InfixExpression)
+
+	tests := []struct {
+		node           ast.Node
+		expectedLine   int
+		expectedColumn int
+	}{
+		{stmt, 1, 1},
+		{function, 1, 11},
+		{function.Parameters[1], 1, 17},
+		{infix.Left, 2, 3},
+		{infix, 2, 5},
+	}
+
+	for i, tt := range tests {
+		pos := tt.node.Pos()
+		if pos.Line != tt.expectedLine || pos.Column != tt.expectedColumn {
+			t.Errorf("tests[%d] - wrong position for %q. want=%d:%d, got=%d:%d",
+				i, tt.node.String(), tt.expectedLine, tt.expectedColumn, pos.Line, pos.Column)
+		}
+	}
+}
+
+func TestWhileStatement(t *testing.T) {
+	input := `while (x < y) { x; break; }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d\n",
+			1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.WhileStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.WhileStatement. got=%T",
+			program.Statements[0])
+	}
+
+	if !testInfixExpression(t, stmt.Condition, "x", "<", "y") {
+		return
+	}
+
+	if len(stmt.Body.Statements) != 2 {
+		t.Fatalf("body is not 2 statements. got=%d\n", len(stmt.Body.Statements))
+	}
+
+	if _, ok := stmt.Body.Statements[1].(*ast.BreakStatement); !ok {
+		t.Fatalf("Statements[1] is not ast.BreakStatement. got=%T",
+			stmt.Body.Statements[1])
+	}
+}
+
+func TestForStatement(t *testing.T) {
+	input := `for (x in [1, 2]) { continue; }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ast.ForStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ForStatement. got=%T",
+			program.Statements[0])
+	}
+
+	if !testIdentifier(t, stmt.Variable, "x") {
+		return
+	}
+
+	array, ok := stmt.Iterable.(*ast.ArrayLiteral)
+	if !ok || len(array.Elements) != 2 {
+		t.Fatalf("stmt.Iterable is not an array of 2 elements. got=%T", stmt.Iterable)
+	}
+
+	if _, ok := stmt.Body.Statements[0].(*ast.ContinueStatement); !ok {
+		t.Fatalf("Statements[0] is not ast.ContinueStatement. got=%T",
+			stmt.Body.Statements[0])
+	}
+
+	if stmt.String() != "for (x in [1, 2]) continue;" {
+		t.Errorf("stmt.String() wrong. got=%q", stmt.String())
+	}
+}
+
+func TestLoopControlOutsideLoop(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"break;", "1:1: break outside of a loop"},
+		{"if (true) { continue; }", "1:13: continue outside of a loop"},
+		{"while (true) { fn() { break; } }", "1:23: break outside of a loop"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != 1 {
+			t.Fatalf("expected 1 parser error for %q, got %v", tt.input, errors)
+		}
+		if errors[0] != tt.expectedError {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expectedError, errors[0])
+		}
+	}
+}
+
+func TestAssignExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x = 5;", "x = 5"},
+		{"x += y * 2;", "x += (y * 2)"},
+		{"x -= 1", "x -= 1"},
+		{"x *= 2", "x *= 2"},
+		{"x /= 2", "x /= 2"},
+		{"a = b = c", "a = b = c"},
+		{"a = b || c", "a = (b || c)"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		exp, ok := stmt.Expression.(*ast.AssignExpression)
+		if !ok {
+			t.Fatalf("stmt.Expression is not ast.AssignExpression. got=%T", stmt.Expression)
+		}
+		if exp.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, exp.String())
+		}
+	}
+}
+
+func TestIndexAssignExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"arr[0] = 5;", "arr[0] = 5"},
+		{`h["k"] += 1 * 2;`, `h[k] += (1 * 2)`},
+		{"m[i][j] = 0", "(m[i])[j] = 0"},
+		{"a[0] = b[1] = 2", "a[0] = b[1] = 2"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		exp, ok := stmt.Expression.(*ast.IndexAssignExpression)
+		if !ok {
+			t.Fatalf("stmt.Expression is not ast.IndexAssignExpression. got=%T", stmt.Expression)
+		}
+		if exp.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, exp.String())
+		}
+	}
+}
+
+func TestAssignExpressionInvalidTarget(t *testing.T) {
+	l := lexer.New("1 = 2;")
+	p := New(l)
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) == 0 {
+		t.Fatalf("expected parser errors, got none")
+	}
+	if errors[0] != "1:3: cannot assign to 1" {
+		t.Errorf("wrong error. got=%q", errors[0])
+	}
+}
+
+func TestNodeRanges(t *testing.T) {
+	input := `let add = fn(a, b) {
+  a + b
+};
+add(1, [2, 3][0]) * -x;
+"a
+b";
+{"k": true}`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	let := program.Statements[0].(*ast.LetStatement)
+	function := let.Value.(*ast.FunctionLiteral)
+	body := function.Body.Statements[0].(*ast.ExpressionStatement)
+	product := program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.InfixExpression)
+	call := product.Left.(*ast.CallExpression)
+	index := call.Arguments[1].(*ast.IndexExpression)
+
+	tests := []struct {
+		node          ast.Node
+		expectedRange string
+	}{
+		{let, "1:1-3:2"},
+		{function, "1:11-3:2"},
+		{body, "2:3-2:8"},
+		{product, "4:1-4:23"},
+		{call, "4:1-4:18"},
+		{index, "4:8-4:17"},
+		{index.Left, "4:8-4:14"},
+		{product.Right, "4:21-4:23"},
+		{program.Statements[2], "5:1-6:3"},
+		{program.Statements[3], "7:1-7:12"},
+		{program, "1:1-7:12"},
+	}
+
+	for i, tt := range tests {
+		rng := ast.Range(tt.node)
+		if rng.String() != tt.expectedRange {
+			t.Errorf("tests[%d] - wrong range for %q. want=%s, got=%s",
+				i, tt.node.String(), tt.expectedRange, rng)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package repl
+
+import (
+	"fmt"
+	"io"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/token"
+	"strings"
+)
+
+const COMMAND_HELP = `Commands:
+  :tokens <input>     print the tokens produced by the lexer
+  :ast <input>        print the parsed syntax tree
+  :bytecode <input>   print the compiled instructions and constant pool
+  :globals            list global bindings and their current values
+  :reset              forget all bindings, macros and constants
+  :help               show this message
+`
+
+// isCommand 以 ':' 开头的输入是REPL的命令而不是Monkey代码
+func isCommand(input string) bool {
+	return strings.HasPrefix(strings.TrimSpace(input), ":")
+}
+
+// splitCommand 把 ":ast 1 + 2" 拆成 "ast" 和 "1 + 2"
+func splitCommand(input string) (string, string) {
+	input = strings.TrimPrefix(strings.TrimSpace(input), ":")
+	name, arg, _ := strings.Cut(input, " ")
+	if i := strings.IndexAny(name, "\n\t"); i >= 0 {
+		name, arg = name[:i], name[i:]+" "+arg
+	}
+	return name, strings.TrimSpace(arg)
+}
+
+// runCommand 执行一条REPL命令,返回之后要使用的引擎(:reset 会换成新的引擎)
+func runCommand(eng engine, engineName, input string, out io.Writer) engine {
+	name, arg := splitCommand(input)
+	switch name {
+	case "tokens":
+		printTokens(out, arg)
+	case "ast":
+		program, ok := parseInput(out, arg)
+		if ok {
+			ast.Fprint(out, program)
+		}
+	case "bytecode":
+		program, ok := parseInput(out, arg)
+		if ok {
+			printBytecode(out, eng, program)
+		}
+	case "globals":
+		eng.printGlobals(out)
+	case "reset":
+		fresh, _ := newEngine(engineName)
+		io.WriteString(out, "state cleared\n")
+		return fresh
+	case "help":
+		io.WriteString(out, COMMAND_HELP)
+	default:
+		fmt.Fprintf(out, "unknown command :%s\n%s", name, COMMAND_HELP)
+	}
+	return eng
+}
+
+func parseInput(out io.Writer, input string) (*ast.Program, bool) {
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		printParserErrors(out, p.Errors())
+		return nil, false
+	}
+	return program, true
+}
+
+func printTokens(out io.Writer, input string) {
+	l := lexer.New(input)
+	for {
+		tok := l.NextToken()
+		fmt.Fprintf(out, "%-6s %-10s %q\n", tok.Pos, tok.Type, tok.Literal)
+		if tok.Type == token.EOF {
+			return
+		}
+	}
+}
+
+// printBytecode 用引擎当前已知的全局变量和宏编译输入,但不会修改引擎的状态
+func printBytecode(out io.Writer, eng engine, program *ast.Program) {
+	symbolTable := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+	for _, name := range eng.globalNames() {
+		symbolTable.Define(name)
+	}
+
+	comp := compiler.NewWithState(symbolTable, []object.Object{})
+	comp.SetMacroEnv(object.NewEnclosedEnvironment(eng.macros()))
+	err := comp.Compile(program)
+	if err != nil {
+		fmt.Fprintf(out, "Woops! Compilation failed:\n %s\n", err)
+		return
+	}
+
+	bytecode := comp.Bytecode()
+	io.WriteString(out, "Instructions:\n")
+	io.WriteString(out, bytecode.Instructions.String())
+	io.WriteString(out, "Constants:\n")
+	for i, c := range bytecode.Constants {
+		fmt.Fprintf(out, "%04d %s %s\n", i, c.Type(), c.Inspect())
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			for _, line := range strings.Split(strings.TrimRight(fn.Instructions.String(), "\n"), "\n") {
+				fmt.Fprintf(out, "     %s\n", line)
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package repl
+
+import (
+	"monkey/lexer"
+	"monkey/token"
+)
+
+const CONTINUATION_PROMPT = ".. "
+
+// continuationTokens 以这些词法单元结尾的输入显然还没有写完
+var continuationTokens = map[token.TokenType]bool{
+	token.PLUS_ASSIGN:     true,
+	token.MINUS_ASSIGN:    true,
+	token.ASTERISK_ASSIGN: true,
+	token.SLASH_ASSIGN:    true,
+	token.ASSIGN:   true,
+	token.PLUS:     true,
+	token.MINUS:    true,
+	token.BANG:     true,
+	token.ASTERISK: true,
+	token.SLASH:    true,
+	token.PERCENT:  true,
+	token.LT:       true,
+	token.GT:       true,
+	token.LT_EQ:    true,
+	token.GT_EQ:    true,
+	token.AND:      true,
+	token.OR:       true,
+	token.EQ:       true,
+	token.NOT_EQ:   true,
+	token.COMMA:    true,
+	token.COLON:    true,
+	token.LET:      true,
+	token.IF:       true,
+	token.ELSE:     true,
+	token.WHILE:    true,
+	token.FOR:      true,
+	token.IN:       true,
+	token.FUNCTION: true,
+	token.MACRO:    true,
+}
+
+// isComplete 判断输入是否已经可以交给解析器:
+// 括号都已配对、字符串已经闭合,并且没有以运算符之类的词法单元结尾
+func isComplete(input string) bool {
+	if !stringsClosed(input) {
+		return false
+	}
+
+	l := lexer.New(input)
+	depth := 0
+	var last token.Token
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		switch tok.Type {
+		case token.LPAREN, token.LBRACE, token.LBRACKET:
+			depth++
+		case token.RPAREN, token.RBRACE, token.RBRACKET:
+			depth--
+		}
+		last = tok
+	}
+
+	if depth > 0 {
+		return false
+	}
+	return !continuationTokens[last.Type]
+}
+
+// stringsClosed 字符串是否都已闭合。和词法分析器的 readString 一样,
+// 字符串里没有转义,一直到下一个引号为止
+func stringsClosed(input string) bool {
+	inString := false
+	for i := 0; i < len(input); i++ {
+		if input[i] == '"' {
+			inString = !inString
+		}
+	}
+	return !inString
+}
@@ -0,0 +1,33 @@
+package repl
+
+import "testing"
+
+func TestIsComplete(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"1 + 2", true},
+		{"let add = fn(a, b) {", false},
+		{"let add = fn(a, b) {\n  a + b\n};", true},
+		{"[1, 2,", false},
+		{"[1, 2,\n 3]", true},
+		{`{"a": 1,`, false},
+		{"puts(1,\n", false},
+		{"1 +", false},
+		{"let x =", false},
+		{"if (x > 1) { x } else", false},
+		{`"unterminated`, false},
+		{`"a" + "b"`, true},
+		{`"a\"`, true},
+		{`"a\"b"`, false},
+		{"}", true},
+		{"", true},
+	}
+
+	for _, tt := range tests {
+		if got := isComplete(tt.input); got != tt.expected {
+			t.Errorf("isComplete(%q) wrong. want=%t, got=%t", tt.input, tt.expected, got)
+		}
+	}
+}
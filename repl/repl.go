@@ -4,33 +4,58 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"monkey/ast"
 	"monkey/compiler"
+	"monkey/evaluator"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
 	"monkey/vm"
+	"strings"
 )
 
 const PROMPT = ">> "
 
+// 可选的执行引擎
+const (
+	EngineVM   = "vm"
+	EngineEval = "eval"
+)
+
+// engine 执行解析好的程序,并在多次输入之间保留状态
+type engine interface {
+	execute(program *ast.Program, out io.Writer)
+	// printGlobals 打印所有全局绑定和它们当前的值
+	printGlobals(out io.Writer)
+	// globalNames 按定义顺序返回全局绑定的名字
+	globalNames() []string
+	// macros 返回保存宏定义的环境
+	macros() *object.Environment
+}
+
 // Start ...
-func Start(in io.Reader, out io.Writer) {
+func Start(in io.Reader, out io.Writer, engineName string) {
 	scanner := bufio.NewScanner(in)
 
-	constants := []object.Object{}
-	globals := make([]object.Object, vm.GlobalsSize)
-	symbolTable := compiler.NewSymbolTable()
-	// env := object.NewEnvironment()
-	// macroEnv := object.NewEnvironment()
+	eng, err := newEngine(engineName)
+	if err != nil {
+		fmt.Fprintf(out, "%s\n", err)
+		return
+	}
 	for {
-		fmt.Fprintf(out, PROMPT)
-		scanned := scanner.Scan()
-		if !scanned {
+		input, ok := readInput(scanner, out)
+		if !ok {
 			return
 		}
+		if strings.TrimSpace(input) == "" {
+			continue
+		}
+		if isCommand(input) {
+			eng = runCommand(eng, engineName, input, out)
+			continue
+		}
 
-		line := scanner.Text()
-		l := lexer.New(line)
+		l := lexer.New(input)
 		p := parser.New(l)
 
 		program := p.ParseProgram()
@@ -39,27 +64,162 @@ func Start(in io.Reader, out io.Writer) {
 			continue
 		}
 
-		comp := compiler.NewWithState(symbolTable, constants)
-		err := comp.Compile(program)
-		if err != nil {
-			fmt.Fprintf(out, "Woops! Compilation failed:\n %s\n", err)
-			continue
+		eng.execute(program, out)
+	}
+}
+
+// readInput 读入一段完整的输入。
+// 输入不完整时(比如括号没有配对)用 CONTINUATION_PROMPT 继续读下一行,
+// 在续行时输入空行会直接提交已经读到的内容
+func readInput(scanner *bufio.Scanner, out io.Writer) (string, bool) {
+	var lines []string
+	prompt := PROMPT
+	for {
+		io.WriteString(out, prompt)
+		if !scanner.Scan() {
+			return strings.Join(lines, "\n"), len(lines) > 0
 		}
-		code := comp.Bytecode()
-		constants = code.Constants
-		machine := vm.NewWithGlobalsStore(code, globals)
-		err = machine.Run()
-		if err != nil {
-			fmt.Fprintf(out, "Woops! Executing bytecode failed:\n %s\n", err)
-			continue
+
+		line := scanner.Text()
+		if len(lines) > 0 && strings.TrimSpace(line) == "" {
+			return strings.Join(lines, "\n"), true
+		}
+		lines = append(lines, line)
+
+		input := strings.Join(lines, "\n")
+		code := input
+		if isCommand(input) {
+			_, code = splitCommand(input)
+		}
+		if isComplete(code) {
+			return input, true
 		}
+		prompt = CONTINUATION_PROMPT
+	}
+}
 
-		lastPopped := machine.LastPoppedStackElem()
-		io.WriteString(out, lastPopped.Inspect())
+func newEngine(name string) (engine, error) {
+	switch name {
+	case EngineVM:
+		return newVMEngine(), nil
+	case EngineEval:
+		return newEvalEngine(), nil
+	default:
+		return nil, fmt.Errorf("unknown engine %q, use %q or %q", name, EngineVM, EngineEval)
+	}
+}
+
+// vmEngine 编译成字节码后在虚拟机上执行
+type vmEngine struct {
+	macroEnv    *object.Environment
+	constants   []object.Object
+	globals     []object.Object
+	symbolTable *compiler.SymbolTable
+}
+
+func newVMEngine() *vmEngine {
+	symbolTable := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+	return &vmEngine{
+		macroEnv:    object.NewEnvironment(),
+		constants:   []object.Object{},
+		globals:     make([]object.Object, vm.GlobalsSize),
+		symbolTable: symbolTable,
+	}
+}
+
+func (e *vmEngine) execute(program *ast.Program, out io.Writer) {
+	comp := compiler.NewWithState(e.symbolTable, e.constants)
+	comp.SetMacroEnv(e.macroEnv)
+	err := comp.Compile(program)
+	if err != nil {
+		fmt.Fprintf(out, "Woops! Compilation failed:\n %s\n", err)
+		return
+	}
+	code := comp.Bytecode()
+	e.constants = code.Constants
+	machine := vm.NewWithGlobalsStore(code, e.globals)
+	err = machine.Run()
+	if err != nil {
+		fmt.Fprintf(out, "Woops! Executing bytecode failed:\n %s\n", err)
+		if rerr, ok := err.(*vm.RuntimeError); ok {
+			io.WriteString(out, rerr.StackTrace())
+		}
+		return
+	}
+
+	lastPopped := machine.LastPoppedStackElem()
+	io.WriteString(out, lastPopped.Inspect())
+	io.WriteString(out, "\n")
+}
+
+func (e *vmEngine) printGlobals(out io.Writer) {
+	for _, s := range e.symbolTable.GlobalSymbols() {
+		value := "<unset>"
+		if obj := e.globals[s.Index]; obj != nil {
+			value = obj.Inspect()
+		}
+		fmt.Fprintf(out, "%04d %s = %s\n", s.Index, s.Name, value)
+	}
+}
+
+func (e *vmEngine) globalNames() []string {
+	names := []string{}
+	for _, s := range e.symbolTable.GlobalSymbols() {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+func (e *vmEngine) macros() *object.Environment {
+	return e.macroEnv
+}
+
+// evalEngine 展开宏之后直接遍历 AST 求值
+type evalEngine struct {
+	env      *object.Environment
+	macroEnv *object.Environment
+}
+
+func newEvalEngine() *evalEngine {
+	return &evalEngine{
+		env:      object.NewEnvironment(),
+		macroEnv: object.NewEnvironment(),
+	}
+}
+
+func (e *evalEngine) execute(program *ast.Program, out io.Writer) {
+	evaluator.DefineMacros(program, e.macroEnv)
+	expanded, err := evaluator.ExpandProgram(program, e.macroEnv)
+	if err != nil {
+		fmt.Fprintf(out, "Woops! %s\n", err)
+		return
+	}
+
+	evaluated := evaluator.Eval(expanded, e.env)
+	if evaluated != nil {
+		io.WriteString(out, evaluated.Inspect())
 		io.WriteString(out, "\n")
 	}
 }
 
+func (e *evalEngine) printGlobals(out io.Writer) {
+	for _, name := range e.env.Names() {
+		value, _ := e.env.Get(name)
+		fmt.Fprintf(out, "%s = %s\n", name, value.Inspect())
+	}
+}
+
+func (e *evalEngine) globalNames() []string {
+	return e.env.Names()
+}
+
+func (e *evalEngine) macros() *object.Environment {
+	return e.macroEnv
+}
+
 const MONKEY_FACE = `            __,__
    .--.  .-"     "-.  .--.
   / .. \/  .-. .-.  \/ .. \
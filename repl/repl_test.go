@@ -0,0 +1,76 @@
+package repl
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCommands(t *testing.T) {
+	tests := []struct {
+		engine   string
+		input    string
+		expected []string
+	}{
+		{
+			EngineVM,
+			"let a = 5;\n:globals\n",
+			[]string{"0000 a = 5"},
+		},
+		{
+			EngineEval,
+			"let a = 5;\n:globals\n",
+			[]string{"a = 5"},
+		},
+		{
+			EngineVM,
+			"let a = 5;\n:bytecode a + 1\n",
+			[]string{"0000 OpGetGlobal 0\n0003 OpConstant 0\n0006 OpAdd\n0007 OpPop\n", "0000 INTEGER 1"},
+		},
+		{
+			EngineVM,
+			":tokens let x\n",
+			[]string{"1:1    LET        \"let\"", "1:5    IDENT      \"x\""},
+		},
+		{
+			EngineVM,
+			":ast -a\n",
+			[]string{"Expression: *ast.PrefixExpression 1:1 Operator=\"-\""},
+		},
+		{
+			EngineVM,
+			"let a = 5;\n:reset\na\n",
+			[]string{"state cleared", "undefined variable a"},
+		},
+		{
+			EngineVM,
+			"for (x in []) { }\n",
+			[]string{">> null\n"},
+		},
+		{
+			EngineVM,
+			"let i = 0;\nwhile (false) { }\n",
+			[]string{">> null\n"},
+		},
+		{
+			EngineEval,
+			"let m = macro() { 1 };\nm();\n1 + 1\n",
+			[]string{"1:1: macro expansion failed", ">> 2\n"},
+		},
+		{
+			EngineVM,
+			"let add = fn(a, b) {\n  a + b\n};\nadd(1,\n2)\n",
+			[]string{">> .. 3\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		Start(strings.NewReader(tt.input), &out, tt.engine)
+		for _, expected := range tt.expected {
+			if !strings.Contains(out.String(), expected) {
+				t.Errorf("output for %q does not contain %q. got=%q", tt.input, expected, out.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/repl"
+	"monkey/vm"
+	"os"
+)
+
+// runCommand 执行 `monkey run <file> [args...]`,返回进程的退出码。
+// file 可以是源文件,也可以是 `monkey build` 生成的字节码文件
+func runCommand(engine string, args []string) int {
+	if len(args) < 1 {
+		fmt.Fprintf(os.Stderr, "run: missing file name\n")
+		return 2
+	}
+	filename := args[0]
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "run: %s\n", err)
+		return 1
+	}
+	if compiler.IsBytecode(source) {
+		if engine == repl.EngineEval {
+			fmt.Fprintf(os.Stderr, "run: %s is compiled bytecode and can only run on the vm engine\n", filename)
+			return 2
+		}
+		bytecode, err := compiler.Deserialize(bytes.NewReader(source))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "run: %s: %s\n", filename, err)
+			return 1
+		}
+		return runBytecode(os.Stderr, bytecode, args[1:])
+	}
+	return runSource(os.Stderr, engine, filename, string(source), args[1:])
+}
+
+// runSource 用指定的引擎执行一整个源文件。
+// 脚本的参数以字符串数组的形式绑定在全局变量 args 上
+func runSource(errOut io.Writer, engine, filename, source string, scriptArgs []string) int {
+	program, ok := parseSource(errOut, filename, source)
+	if !ok {
+		return 1
+	}
+
+	if engine == repl.EngineEval {
+		return evalProgram(errOut, program, scriptArgs)
+	}
+	return runProgram(errOut, program, scriptArgs)
+}
+
+// parseSource 解析源文件,有语法错误时输出到 errOut
+func parseSource(errOut io.Writer, filename, source string) (*ast.Program, bool) {
+	l := lexer.NewWithFilename(filename, source)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		for _, msg := range p.Errors() {
+			fmt.Fprintf(errOut, "%s\n", msg)
+		}
+		return nil, false
+	}
+	return program, true
+}
+
+// newScriptSymbolTable 脚本的全局符号表:内置函数加上 args。
+// 编译和执行字节码文件都依赖 args 的下标,所以它必须第一个定义
+func newScriptSymbolTable() (*compiler.SymbolTable, compiler.Symbol) {
+	symbolTable := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+	return symbolTable, symbolTable.Define("args")
+}
+
+// compileProgram 按 -O 指定的优化级别把脚本编译成字节码
+func compileProgram(program *ast.Program) (*compiler.Bytecode, error) {
+	symbolTable, _ := newScriptSymbolTable()
+	comp := compiler.NewWithState(symbolTable, []object.Object{})
+	comp.SetOptimizationLevel(*optimizationLevel)
+	err := comp.Compile(program)
+	if err != nil {
+		return nil, err
+	}
+	return comp.Bytecode(), nil
+}
+
+// runProgram 编译后在虚拟机上执行
+func runProgram(errOut io.Writer, program *ast.Program, scriptArgs []string) int {
+	bytecode, err := compileProgram(program)
+	if err != nil {
+		fmt.Fprintf(errOut, "compile error: %s\n", err)
+		return 1
+	}
+	return runBytecode(errOut, bytecode, scriptArgs)
+}
+
+// runBytecode 在虚拟机上执行编译好的字节码
+func runBytecode(errOut io.Writer, bytecode *compiler.Bytecode, scriptArgs []string) int {
+	_, argsSymbol := newScriptSymbolTable()
+	globals := make([]object.Object, vm.GlobalsSize)
+	globals[argsSymbol.Index] = argsArray(scriptArgs)
+
+	machine := vm.NewWithGlobalsStore(bytecode, globals)
+	err := machine.Run()
+	if err != nil {
+		fmt.Fprintf(errOut, "runtime error: %s\n", err)
+		if rerr, ok := err.(*vm.RuntimeError); ok {
+			io.WriteString(errOut, rerr.StackTrace())
+		}
+		return 1
+	}
+	return 0
+}
+
+// evalProgram 展开宏之后用求值器执行
+func evalProgram(errOut io.Writer, program *ast.Program, scriptArgs []string) int {
+	env := object.NewEnvironment()
+	macroEnv := object.NewEnvironment()
+	env.Set("args", argsArray(scriptArgs))
+
+	evaluator.DefineMacros(program, macroEnv)
+	expanded, err := evaluator.ExpandProgram(program, macroEnv)
+	if err != nil {
+		fmt.Fprintf(errOut, "error: %s\n", err)
+		return 1
+	}
+
+	evaluated := evaluator.Eval(expanded, env)
+	if errObj, ok := evaluated.(*object.Error); ok {
+		if errObj.Pos.IsValid() {
+			fmt.Fprintf(errOut, "runtime error: %s: %s\n", errObj.Pos, errObj.Message)
+		} else {
+			fmt.Fprintf(errOut, "runtime error: %s\n", errObj.Message)
+		}
+		return 1
+	}
+	return 0
+}
+
+// argsArray 把命令行参数转换成 Monkey 的字符串数组
+func argsArray(args []string) *object.Array {
+	elements := make([]object.Object, len(args))
+	for i, arg := range args {
+		elements[i] = &object.String{Value: arg}
+	}
+	return &object.Array{Elements: elements}
+}
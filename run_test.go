@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"monkey/compiler"
+	"monkey/repl"
+	"strings"
+	"testing"
+)
+
+func TestRunSource(t *testing.T) {
+	tests := []struct {
+		input          string
+		args           []string
+		expectedStatus int
+		expectedError  string
+	}{
+		{`let x = 1 + 2; x;`, nil, 0, ""},
+		{`if (len(args) != 2) { -true } else { first(args) + last(args) }`, []string{"a", "b"}, 0, ""},
+		{`if (len(args) != 2) { -true }`, []string{"a"}, 1, "runtime error: unsupported type for negation: BOOLEAN"},
+		{"let a = 1;\nlet b = ;", nil, 1, "script.mk:2:9: no prefix parse function for ; found"},
+		{"let a = 1;\nfoo(a);", nil, 1, "compile error: script.mk:2:1: undefined variable foo"},
+		{"let f = fn(x) {\n  x + true\n};\nf(1);", nil, 1,
+			"runtime error: unsupported types for binary operation: INTEGER BOOLEAN\n" +
+				"    at f (script.mk:2:3) [ip 0003]\n" +
+				"    at <main> (script.mk:4:1) [ip 0013]\n"},
+	}
+
+	for _, tt := range tests {
+		var errOut bytes.Buffer
+		status := runSource(&errOut, repl.EngineVM, "script.mk", tt.input, tt.args)
+		if status != tt.expectedStatus {
+			t.Errorf("wrong exit status for %q. want=%d, got=%d (%s)",
+				tt.input, tt.expectedStatus, status, errOut.String())
+		}
+		if tt.expectedError != "" && !strings.Contains(errOut.String(), tt.expectedError) {
+			t.Errorf("wrong error output for %q. want=%q, got=%q",
+				tt.input, tt.expectedError, errOut.String())
+		}
+	}
+}
+
+func TestRunSourceWithEvaluator(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedStatus int
+		expectedError  string
+	}{
+		{`let unless = macro(cond, cons, alt) { quote(if (!(unquote(cond))) { unquote(cons) } else { unquote(alt) }) };
+unless(len(args) > 1, -true, 1);`, 0, ""},
+		{"let a = 1;\n  a + true;", 1, "runtime error: script.mk:2:5: type mismatch: INTEGER + BOOLEAN"},
+		{"let m = macro() { 1 };\nlet x = m();", 1,
+			"error: script.mk:2:1: macro expansion failed: we only suppert returning AST-nodes from macros"},
+	}
+
+	for _, tt := range tests {
+		var errOut bytes.Buffer
+		status := runSource(&errOut, repl.EngineEval, "script.mk", tt.input, []string{"a", "b"})
+		if status != tt.expectedStatus {
+			t.Errorf("wrong exit status for %q. want=%d, got=%d (%s)",
+				tt.input, tt.expectedStatus, status, errOut.String())
+		}
+		if tt.expectedError != "" && !strings.Contains(errOut.String(), tt.expectedError) {
+			t.Errorf("wrong error output for %q. want=%q, got=%q",
+				tt.input, tt.expectedError, errOut.String())
+		}
+	}
+}
+
+func TestBuildAndRunBytecode(t *testing.T) {
+	input := "let f = fn(x) {\n  x + true\n};\nif (len(args) == 1) { f(1) };"
+
+	var out, errOut bytes.Buffer
+	if status := buildSource(&errOut, &out, "script.mk", input); status != 0 {
+		t.Fatalf("build failed: %s", errOut.String())
+	}
+
+	bytecode, err := compiler.Deserialize(&out)
+	if err != nil {
+		t.Fatalf("deserialize error: %s", err)
+	}
+
+	errOut.Reset()
+	if status := runBytecode(&errOut, bytecode, nil); status != 0 {
+		t.Errorf("wrong exit status without args. got=%d (%s)", status, errOut.String())
+	}
+
+	errOut.Reset()
+	status := runBytecode(&errOut, bytecode, []string{"a"})
+	expected := "runtime error: unsupported types for binary operation: INTEGER BOOLEAN\n" +
+		"    at f (script.mk:2:3) [ip 0003]\n"
+	if status != 1 || !strings.Contains(errOut.String(), expected) {
+		t.Errorf("wrong result with args. status=%d, want=%q, got=%q", status, expected, errOut.String())
+	}
+
+	errOut.Reset()
+	if status := buildSource(&errOut, &out, "script.mk", "foo;"); status != 1 ||
+		!strings.Contains(errOut.String(), "compile error: script.mk:1:1: undefined variable foo") {
+		t.Errorf("wrong build error. status=%d, got=%q", status, errOut.String())
+	}
+}
+
+func TestDisasmSource(t *testing.T) {
+	var out, errOut bytes.Buffer
+	if status := disasmSource(&out, &errOut, "script.mk", []byte(`puts(args);`)); status != 0 {
+		t.Fatalf("disasm failed: %s", errOut.String())
+	}
+	expected := "main:\n  0000 OpGetBuiltin 1  ; puts\n  0002 OpGetGlobal 0\n  0005 OpCall 1\n  0007 OpPop\n"
+	if out.String() != expected {
+		t.Errorf("wrong disassembly. want=%q, got=%q", expected, out.String())
+	}
+
+	var built bytes.Buffer
+	if status := buildSource(&errOut, &built, "script.mk", `puts(args);`); status != 0 {
+		t.Fatalf("build failed: %s", errOut.String())
+	}
+	out.Reset()
+	if status := disasmSource(&out, &errOut, "script.mkc", built.Bytes()); status != 0 {
+		t.Fatalf("disasm of bytecode failed: %s", errOut.String())
+	}
+	if out.String() != expected {
+		t.Errorf("wrong disassembly of bytecode. want=%q, got=%q", expected, out.String())
+	}
+}
@@ -1,10 +1,69 @@
 package token
 
+import "fmt"
+
 type TokenType string
 
 type Token struct {
 	Type    TokenType
 	Literal string
+	Pos     Position //词法单元第一个字符所在的位置
+}
+
+// Position 源代码中的位置,行和列都从1开始
+type Position struct {
+	Filename string
+	Line     int
+	Column   int
+}
+
+// Range 源代码中的一段范围,End 是最后一个字符之后的位置
+type Range struct {
+	Start Position
+	End   Position
+}
+
+// String 返回 "file:line:col-line:col" 形式的范围
+func (r Range) String() string {
+	if !r.End.IsValid() {
+		return r.Start.String()
+	}
+	return fmt.Sprintf("%s-%d:%d", r.Start, r.End.Line, r.End.Column)
+}
+
+// Advance 返回读完 s 以后的位置,无效的位置原样返回
+func (p Position) Advance(s string) Position {
+	if !p.IsValid() {
+		return p
+	}
+	for _, ch := range s {
+		if ch == '\n' {
+			p.Line++
+			p.Column = 1
+		} else {
+			p.Column++
+		}
+	}
+	return p
+}
+
+// IsValid 行号大于0的位置才是有效的
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+// String 返回 "file:line:col" 形式的位置,没有文件名时返回 "line:col"
+func (p Position) String() string {
+	if !p.IsValid() {
+		if p.Filename != "" {
+			return p.Filename
+		}
+		return "-"
+	}
+	if p.Filename == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.Filename, p.Line, p.Column)
 }
 
 const (
@@ -13,6 +72,7 @@ const (
 
 	IDENT = "IDENT" // add,foobar,x,y
 	INT   = "INT"   //132131
+	FLOAT = "FLOAT" //3.14 1e-9
 
 	//运算符
 	ASSIGN   = "="
@@ -21,8 +81,18 @@ const (
 	BANG     = "!"
 	ASTERISK = "*"
 	SLASH    = "/"
+	PERCENT  = "%"
 	LT       = "<"
 	GT       = ">"
+	LT_EQ    = "<="
+	GT_EQ    = ">="
+	AND      = "&&"
+	OR       = "||"
+
+	PLUS_ASSIGN     = "+="
+	MINUS_ASSIGN    = "-="
+	ASTERISK_ASSIGN = "*="
+	SLASH_ASSIGN    = "/="
 
 	//分隔符
 	COMMA     = ","
@@ -43,6 +113,11 @@ const (
 	IF       = "IF"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
+	WHILE    = "WHILE"
+	FOR      = "FOR"
+	IN       = "IN"
+	BREAK    = "BREAK"
+	CONTINUE = "CONTINUE"
 	STRING = "STRING"
 
 	EQ     = "=="
@@ -61,6 +136,11 @@ var keywords = map[string]TokenType{
 	"if":     IF,
 	"else":   ELSE,
 	"return": RETURN,
+	"while":    WHILE,
+	"for":      FOR,
+	"in":       IN,
+	"break":    BREAK,
+	"continue": CONTINUE,
 	"macro": MACRO,
 }
 
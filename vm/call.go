@@ -0,0 +1,102 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"monkey/object"
+)
+
+// Call 调用 Monkey 的闭包或内置函数并返回结果。
+// 一般在 Run 结束之后调用,闭包里用到的全局变量和常量都来自这个虚拟机
+func (vm *VM) Call(fn object.Object, args ...object.Object) (object.Object, error) {
+	return vm.CallContext(context.Background(), fn, args...)
+}
+
+// CallContext 和 Call 一样,但 ctx 被取消时停止执行并返回 ErrCancelled
+func (vm *VM) CallContext(ctx context.Context, fn object.Object, args ...object.Object) (result object.Object, err error) {
+	sp, framesIndex := vm.sp, vm.framesIndex
+	vm.done = ctx.Done()
+	defer func() {
+		if r := recover(); r != nil {
+			err = vm.newRuntimeError(fmt.Errorf("internal error: %v", r))
+		}
+		if err != nil {
+			vm.sp, vm.framesIndex = sp, framesIndex
+		}
+	}()
+
+	result, err = vm.call(fn, args)
+	if err != nil {
+		return nil, vm.newRuntimeError(err)
+	}
+	return result, nil
+}
+
+// call 在当前的栈上调用 fn,被调用的函数返回时结束。
+// 可以在执行指令的过程中重入,内置函数就是这样调用 Monkey 函数的
+func (vm *VM) call(fn object.Object, args []object.Object) (object.Object, error) {
+	framesIndex, stopFrame := vm.framesIndex, vm.stopFrame
+	defer func() { vm.stopFrame = stopFrame }()
+
+	if err := vm.push(fn); err != nil {
+		return nil, err
+	}
+	for _, arg := range args {
+		if err := vm.push(arg); err != nil {
+			return nil, err
+		}
+	}
+	if err := vm.executeCall(len(args)); err != nil {
+		return nil, err
+	}
+	if vm.framesIndex > framesIndex {
+		vm.stopFrame = framesIndex
+		if err := vm.run(); err != nil {
+			return nil, err
+		}
+	}
+	return vm.pop(), nil
+}
+
+// caller 传给内置函数的 object.Caller。
+// 被调用的函数出错时记下带调用栈的错误,内置函数返回后 callBuiltin 把它当作运行时错误,
+// 这样执行限制和取消也会中止整个程序,而不是变成内置函数的返回值
+type caller struct {
+	vm *VM
+	//内置函数通过 Allocate 登记过的字节数
+	allocated int64
+}
+
+func (c *caller) Call(fn object.Object, args ...object.Object) object.Object {
+	vm := c.vm
+	if vm.callErr != nil {
+		return &object.Error{Message: vm.callErr.Error()}
+	}
+	sp, framesIndex := vm.sp, vm.framesIndex
+	result, err := vm.call(fn, args)
+	if err != nil {
+		vm.callErr = vm.newRuntimeError(err)
+		vm.sp, vm.framesIndex = sp, framesIndex
+		return &object.Error{Message: err.Error()}
+	}
+	return result
+}
+
+// Allocate 超出限制或者被取消时也记下错误,和回调出错一样中止整个程序
+func (c *caller) Allocate(size int64) error {
+	vm := c.vm
+	if vm.callErr != nil {
+		return vm.callErr
+	}
+	c.allocated += size
+	vm.steps++
+	err := vm.allocate(size)
+	if err == nil && vm.steps >= vm.nextCheck {
+		err = vm.checkLimits()
+	}
+	if err != nil {
+		vm.callErr = vm.newRuntimeError(err)
+		return err
+	}
+	return nil
+}
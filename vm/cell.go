@@ -0,0 +1,20 @@
+package vm
+
+import "monkey/object"
+
+// cell 被闭包捕获且会被赋值的变量存放在这里,
+// 栈上的局部变量槽和闭包的 Free 共享同一个 cell,修改对双方都可见
+type cell struct {
+	value object.Object
+}
+
+func (c *cell) Type() object.ObjectType { return c.value.Type() }
+func (c *cell) Inspect() string         { return c.value.Inspect() }
+
+// deref 取出 cell 里的值,普通的值原样返回
+func deref(obj object.Object) object.Object {
+	if c, ok := obj.(*cell); ok {
+		return c.value
+	}
+	return obj
+}
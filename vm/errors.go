@@ -0,0 +1,105 @@
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"monkey/code"
+	"monkey/object"
+	"monkey/token"
+)
+
+// 执行被限制打断时的错误,可以用 errors.Is 从 *RuntimeError 里区分出来
+var (
+	ErrExecutionLimit = object.ErrExecutionLimit
+	ErrCancelled      = object.ErrCancelled
+	ErrMemoryLimit    = object.ErrMemoryLimit
+)
+
+// RuntimeError 虚拟机运行时的错误,Frames 是出错时的调用栈,最内层的帧在最前面
+type RuntimeError struct {
+	Message string
+	Frames  []TraceFrame
+
+	err error
+}
+
+// TraceFrame 调用栈中的一帧
+type TraceFrame struct {
+	//函数名,主程序是 <main>,匿名函数是 <anonymous>
+	Function string
+	//正在执行的指令的偏移量
+	IP int
+	//这条指令对应的源码位置,没有记录时无效
+	Pos token.Position
+}
+
+func (e *RuntimeError) Error() string { return e.Message }
+
+// Unwrap 返回导致这个错误的原始错误,比如 ErrExecutionLimit
+func (e *RuntimeError) Unwrap() error { return e.err }
+
+// 调用栈超过这么多帧时,StackTrace 只输出最内层和最外层的各一半
+const maxTraceFrames = 20
+
+// StackTrace 每帧一行:
+//
+//	at add (main.mk:2:5) [ip 0004]
+func (e *RuntimeError) StackTrace() string {
+	var out bytes.Buffer
+	for i, f := range e.Frames {
+		if len(e.Frames) > maxTraceFrames && i >= maxTraceFrames/2 && i < len(e.Frames)-maxTraceFrames/2 {
+			if i == maxTraceFrames/2 {
+				fmt.Fprintf(&out, "    ... %d more frames\n", len(e.Frames)-maxTraceFrames)
+			}
+			continue
+		}
+		fmt.Fprintf(&out, "    at %s (%s) [ip %04d]\n", f.Function, f.Pos, f.IP)
+	}
+	return out.String()
+}
+
+// newRuntimeError 从当前的帧链构建调用栈,err 已经是 *RuntimeError 时原样返回
+func (vm *VM) newRuntimeError(err error) *RuntimeError {
+	if rerr, ok := err.(*RuntimeError); ok {
+		return rerr
+	}
+	rerr := &RuntimeError{Message: err.Error(), err: err}
+
+	for i := vm.framesIndex - 1; i >= 0; i-- {
+		frame := vm.frames[i]
+		fn := frame.cl.Fn
+
+		ip := instructionStart(fn.Instructions, frame.ip)
+		rng, _ := fn.SourceMap.Lookup(ip)
+
+		name := fn.Name
+		if i == 0 {
+			name = "<main>"
+		} else if name == "" {
+			name = "<anonymous>"
+		}
+
+		rerr.Frames = append(rerr.Frames, TraceFrame{Function: name, IP: ip, Pos: rng.Start})
+	}
+	return rerr
+}
+
+// instructionStart 帧的 ip 可能已经越过了操作数,找到它所在指令的起始偏移量
+func instructionStart(ins code.Instructions, ip int) int {
+	start := 0
+	err := code.Walk(ins, func(pos int, op code.Opcode, operands []int) error {
+		if pos > ip {
+			return errPastIP
+		}
+		start = pos
+		return nil
+	})
+	if err != nil && err != errPastIP {
+		return ip
+	}
+	return start
+}
+
+// errPastIP 让 instructionStart 在越过 ip 之后停止解码
+var errPastIP = errors.New("past ip")
@@ -0,0 +1,36 @@
+package vm
+
+import (
+	"fmt"
+	"monkey/object"
+)
+
+// iterator for-in 循环用的迭代器,只会出现在栈上
+type iterator struct {
+	elements []object.Object
+	index    int
+}
+
+func (it *iterator) Type() object.ObjectType { return "ITERATOR" }
+func (it *iterator) Inspect() string         { return "iterator" }
+
+// newIterator 数组按下标顺序遍历元素,哈希表按排好序的键遍历
+func newIterator(iterable object.Object) (*iterator, error) {
+	switch iterable := iterable.(type) {
+	case *object.Array:
+		return &iterator{elements: iterable.Elements}, nil
+	case *object.Hash:
+		return &iterator{elements: iterable.Keys()}, nil
+	default:
+		return nil, fmt.Errorf("not iterable: %s", iterable.Type())
+	}
+}
+
+func (it *iterator) next() (object.Object, bool) {
+	if it.index >= len(it.elements) {
+		return nil, false
+	}
+	element := it.elements[it.index]
+	it.index++
+	return element, true
+}
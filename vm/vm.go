@@ -1,22 +1,47 @@
 package vm
 
 import (
+	"context"
 	"fmt"
+	"math"
 	"monkey/code"
 	"monkey/compiler"
 	"monkey/object"
 )
 
+// 默认的资源限制,可以用 Options 修改
 const StackSize = 2048
 const GlobalsSize = 65536
 const MaxFrames = 1024
 
-var True = &object.Boolean{Value: true}
-var False = &object.Boolean{Value: false}
-var Null = &object.Null{}
+// Options 虚拟机的资源限制,值为 0 的字段使用对应的默认值。
+// 超出限制时 Run 返回运行时错误而不是让进程崩溃
+type Options struct {
+	//栈的槽数
+	StackSize int
+	//全局变量的个数,Globals 不为空时以 len(Globals) 为准
+	GlobalsSize int
+	//调用栈的最大深度,包括主程序
+	MaxFrames int
+	//全局变量的存储,REPL 用它在多次执行之间保留全局变量
+	Globals []object.Object
+	//最多执行的指令条数,0 表示不限制
+	MaxSteps int
+	//新建的数组、字符串和哈希表最多占用的字节数,按 object.SizeOf 估算,0 表示不限制
+	MaxBytes int64
+	//OpGetBuiltin 使用的内置函数,nil 表示 object.Builtins 里默认的内置函数
+	Builtins *object.Registry
+}
+
+var defaultBuiltins = object.NewRegistry().Builtins()
+
+var True = object.TRUE
+var False = object.FALSE
+var Null = object.NULL
 
 type VM struct {
 	contants []object.Object
+	builtins []*object.Builtin
 
 	stack []object.Object
 	sp    int // 始终指向下一个空闲的栈槽。栈顶元素的索引是sp-1
@@ -25,31 +50,77 @@ type VM struct {
 
 	frames      []*Frame
 	framesIndex int
+
+	//已经执行的指令条数和上限,steps 到 nextCheck 时才检查限制
+	steps     int
+	maxSteps  int
+	nextCheck int
+	//有指令上限或者 ctx 可能被取消时才计数,否则分派循环里不做任何检查
+	limited bool
+	//RunContext 传进来的 ctx 的 Done,不会被取消时是 nil
+	done <-chan struct{}
+
+	//已经分配的字节数和上限
+	bytes    int64
+	maxBytes int64
+
+	//函数返回后帧数降到 stopFrame 时 run 结束,Call 用它只执行被调用的函数
+	stopFrame int
+	//内置函数通过 caller 调用的函数出的错,内置函数返回后报告
+	callErr error
 }
 
 func New(bytecode *compiler.Bytecode) *VM {
-	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions}
+	return NewWithOptions(bytecode, Options{})
+}
+
+func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
+	return NewWithOptions(bytecode, Options{Globals: s})
+}
+
+// NewWithOptions 按 opts 里的限制创建虚拟机
+func NewWithOptions(bytecode *compiler.Bytecode, opts Options) *VM {
+	if opts.StackSize <= 0 {
+		opts.StackSize = StackSize
+	}
+	if opts.MaxFrames <= 0 {
+		opts.MaxFrames = MaxFrames
+	}
+	globals := opts.Globals
+	if globals == nil {
+		if opts.GlobalsSize <= 0 {
+			opts.GlobalsSize = GlobalsSize
+		}
+		globals = make([]object.Object, opts.GlobalsSize)
+	}
+
+	mainFn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		SourceMap:    bytecode.SourceMap,
+	}
 	mainClosure := &object.Closure{Fn: mainFn}
 	mainFrame := NewFrame(mainClosure, 0)
 
-	frames := make([]*Frame, MaxFrames)
+	builtins := defaultBuiltins
+	if opts.Builtins != nil {
+		builtins = opts.Builtins.Builtins()
+	}
+
+	frames := make([]*Frame, opts.MaxFrames)
 	frames[0] = mainFrame
 	return &VM{
 		contants:    bytecode.Constants,
-		stack:       make([]object.Object, StackSize),
+		builtins:    builtins,
+		stack:       make([]object.Object, opts.StackSize),
 		sp:          0,
-		globals:     make([]object.Object, GlobalsSize),
+		globals:     globals,
 		frames:      frames,
 		framesIndex: 1,
+		maxSteps:    opts.MaxSteps,
+		maxBytes:    opts.MaxBytes,
 	}
 }
 
-func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
-	vm := New(bytecode)
-	vm.globals = s
-	return vm
-}
-
 func (vm *VM) StackTop() object.Object {
 	if vm.sp == 0 {
 		return nil
@@ -57,12 +128,46 @@ func (vm *VM) StackTop() object.Object {
 	return vm.stack[vm.sp-1]
 }
 
+// Run ...
+// 运行出错时返回 *RuntimeError,里面带着出错时的调用栈
+//
+// 虚拟机内部的 panic 也会被转换成运行时错误,嵌入虚拟机的程序不会因为脚本崩溃
 func (vm *VM) Run() error {
+	return vm.RunContext(context.Background())
+}
+
+// RunContext 和 Run 一样,但 ctx 被取消时停止执行并返回 ErrCancelled。
+// 超出 Options.MaxSteps 时返回 ErrExecutionLimit,超出 Options.MaxBytes 时返回 ErrMemoryLimit
+func (vm *VM) RunContext(ctx context.Context) (err error) {
+	vm.done = ctx.Done()
+	vm.limited = vm.maxSteps > 0 || vm.done != nil
+	defer func() {
+		if r := recover(); r != nil {
+			err = vm.newRuntimeError(fmt.Errorf("internal error: %v", r))
+		}
+	}()
+
+	err = vm.run()
+	if err != nil {
+		return vm.newRuntimeError(err)
+	}
+	return nil
+}
+
+func (vm *VM) run() error {
 	var ip int
 	var ins code.Instructions
 	var op code.Opcode
 	//ip是当前的指令索引
 	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		if vm.limited {
+			vm.steps++
+			if vm.steps >= vm.nextCheck {
+				if err := vm.checkLimits(); err != nil {
+					return err
+				}
+			}
+		}
 		vm.currentFrame().ip++
 		ip = vm.currentFrame().ip
 		ins = vm.currentFrame().Instructions()
@@ -75,7 +180,7 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
-		case code.OpAdd, code.OpSub, code.OpDiv, code.OpMul:
+		case code.OpAdd, code.OpSub, code.OpDiv, code.OpMul, code.OpMod:
 			err := vm.executeBinaryOperation(op)
 			if err != nil {
 				return err
@@ -90,7 +195,7 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
-		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan:
+		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpGreaterEqual:
 			err := vm.executeComparsion(op)
 			if err != nil {
 				return err
@@ -123,12 +228,18 @@ func (vm *VM) Run() error {
 				return err
 			}
 		case code.OpSetGlobal:
-			globalIndex := code.ReadUint16(ins[ip+1:])
+			globalIndex := int(code.ReadUint16(ins[ip+1:]))
 			vm.currentFrame().ip += 2
+			if globalIndex >= len(vm.globals) {
+				return vm.globalsExhausted(globalIndex)
+			}
 			vm.globals[globalIndex] = vm.pop()
 		case code.OpGetGlobal:
-			globalIndex := code.ReadUint16(ins[ip+1:])
+			globalIndex := int(code.ReadUint16(ins[ip+1:]))
 			vm.currentFrame().ip += 2
+			if globalIndex >= len(vm.globals) {
+				return vm.globalsExhausted(globalIndex)
+			}
 			err := vm.push(vm.globals[globalIndex])
 			if err != nil {
 				return err
@@ -138,7 +249,11 @@ func (vm *VM) Run() error {
 			vm.currentFrame().ip += 2
 			array := vm.buildArray(vm.sp-numElements, vm.sp)
 			vm.sp = vm.sp - numElements
-			err := vm.push(array)
+			err := vm.allocate(object.SizeOf(array))
+			if err != nil {
+				return err
+			}
+			err = vm.push(array)
 			if err != nil {
 				return err
 			}
@@ -151,6 +266,10 @@ func (vm *VM) Run() error {
 				return err
 			}
 			vm.sp = vm.sp - numElements
+			err = vm.allocate(object.SizeOf(hash))
+			if err != nil {
+				return err
+			}
 			err = vm.push(hash)
 			if err != nil {
 				return err
@@ -162,6 +281,24 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+		case code.OpSetIndex:
+			value := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+			err := vm.executeSetIndex(left, index, value)
+			if err != nil {
+				return err
+			}
+		case code.OpDup:
+			n := int(code.ReadUint8(ins[ip+1:]))
+			vm.currentFrame().ip += 1
+			start := vm.sp - n
+			for i := 0; i < n; i++ {
+				err := vm.push(vm.stack[start+i])
+				if err != nil {
+					return err
+				}
+			}
 		case code.OpCall:
 			numArgs := code.ReadUint8(ins[ip+1:])
 			vm.currentFrame().ip += 1
@@ -169,6 +306,13 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+		case code.OpTailCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+			err := vm.executeTailCall(int(numArgs))
+			if err != nil {
+				return err
+			}
 		case code.OpReturnValue:
 			returnValue := vm.pop()
 			frame := vm.popFrame()
@@ -177,6 +321,9 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+			if vm.framesIndex == vm.stopFrame {
+				return nil
+			}
 		case code.OpReturn:
 			frame := vm.popFrame()
 			vm.sp = frame.basePointer - 1
@@ -184,24 +331,34 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+			if vm.framesIndex == vm.stopFrame {
+				return nil
+			}
 		case code.OpSetLocal:
 			localIndex := code.ReadUint8(ins[ip+1:])
 			vm.currentFrame().ip += 1
 			frame := vm.currentFrame()
-			vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
+			slot := frame.basePointer + int(localIndex)
+			if c, ok := vm.stack[slot].(*cell); ok {
+				c.value = vm.pop()
+			} else {
+				vm.stack[slot] = vm.pop()
+			}
 		case code.OpGetLocal:
 			localIndex := code.ReadUint8(ins[ip+1:])
 			vm.currentFrame().ip += 1
 			frame := vm.currentFrame()
-			err := vm.push(vm.stack[frame.basePointer+int(localIndex)])
+			err := vm.push(deref(vm.stack[frame.basePointer+int(localIndex)]))
 			if err != nil {
 				return err
 			}
 		case code.OpGetBuiltin:
 			builtinIndex := code.ReadUint8(ins[ip+1:])
 			vm.currentFrame().ip += 1
-			definition := object.Builtins[builtinIndex]
-			err := vm.push(definition.Builtin)
+			if int(builtinIndex) >= len(vm.builtins) {
+				return fmt.Errorf("unknown builtin: %d", builtinIndex)
+			}
+			err := vm.push(vm.builtins[builtinIndex])
 			if err != nil {
 				return err
 			}
@@ -214,6 +371,37 @@ func (vm *VM) Run() error {
 				return err
 			}
 		case code.OpGetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+			currentClosure := vm.currentFrame().cl
+			err := vm.push(deref(currentClosure.Free[freeIndex]))
+			if err != nil {
+				return err
+			}
+		case code.OpSetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+			currentClosure := vm.currentFrame().cl
+			if c, ok := currentClosure.Free[freeIndex].(*cell); ok {
+				c.value = vm.pop()
+			} else {
+				currentClosure.Free[freeIndex] = vm.pop()
+			}
+		case code.OpCaptureLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+			frame := vm.currentFrame()
+			slot := frame.basePointer + int(localIndex)
+			c, ok := vm.stack[slot].(*cell)
+			if !ok {
+				c = &cell{value: vm.stack[slot]}
+				vm.stack[slot] = c
+			}
+			err := vm.push(c)
+			if err != nil {
+				return err
+			}
+		case code.OpCaptureFree:
 			freeIndex := code.ReadUint8(ins[ip+1:])
 			vm.currentFrame().ip += 1
 			currentClosure := vm.currentFrame().cl
@@ -227,8 +415,88 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+		case code.OpIter:
+			iterable := vm.pop()
+			it, err := newIterator(iterable)
+			if err != nil {
+				return err
+			}
+			err = vm.push(it)
+			if err != nil {
+				return err
+			}
+		case code.OpAddLocalConstant, code.OpSubLocalConstant:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			constIndex := code.ReadUint16(ins[ip+2:])
+			vm.currentFrame().ip += 3
+			left := deref(vm.stack[vm.currentFrame().basePointer+int(localIndex)])
+			binaryOp := code.OpAdd
+			if op == code.OpSubLocalConstant {
+				binaryOp = code.OpSub
+			}
+			err := vm.executeBinaryOperands(binaryOp, left, vm.contants[constIndex])
+			if err != nil {
+				return err
+			}
+		case code.OpJumpNotEqual, code.OpJumpEqual, code.OpJumpNotGreater, code.OpJumpNotGreaterEqual:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+			right := vm.pop()
+			left := vm.pop()
+			result, err := compare(comparisonOf(op), left, right)
+			if err != nil {
+				return err
+			}
+			if !result {
+				vm.currentFrame().ip = pos - 1
+			}
+		case code.OpIterNext:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+			it := vm.stack[vm.sp-1].(*iterator)
+			element, ok := it.next()
+			if !ok {
+				vm.pop()
+				vm.currentFrame().ip = pos - 1
+				continue
+			}
+			err := vm.push(element)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 每执行这么多条指令检查一次 ctx 是否被取消
+const cancelCheckInterval = 1024
+
+// checkLimits 检查指令预算和取消,并算出下一次检查的时机
+func (vm *VM) checkLimits() error {
+	if vm.maxSteps > 0 && vm.steps > vm.maxSteps {
+		return ErrExecutionLimit
+	}
+	if vm.done != nil {
+		select {
+		case <-vm.done:
+			return ErrCancelled
+		default:
 		}
 	}
+	vm.nextCheck = vm.steps + cancelCheckInterval
+	if vm.maxSteps > 0 && vm.nextCheck > vm.maxSteps+1 {
+		vm.nextCheck = vm.maxSteps + 1
+	}
+	return nil
+}
+
+// allocate 记下新分配的 size 个字节,超出 MaxBytes 时返回 ErrMemoryLimit
+func (vm *VM) allocate(size int64) error {
+	vm.bytes += size
+	if vm.maxBytes > 0 && vm.bytes > vm.maxBytes {
+		return ErrMemoryLimit
+	}
 	return nil
 }
 
@@ -276,7 +544,7 @@ func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
 }
 
 func (vm *VM) push(o object.Object) error {
-	if vm.sp >= StackSize {
+	if vm.sp >= len(vm.stack) {
 		return fmt.Errorf("stack overflow")
 	}
 	vm.stack[vm.sp] = o
@@ -291,19 +559,28 @@ func (vm *VM) pop() object.Object {
 }
 
 func (vm *VM) LastPoppedStackElem() object.Object {
+	if vm.sp >= len(vm.stack) {
+		return nil
+	}
 	return vm.stack[vm.sp]
 }
 
 func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	right := vm.pop()
 	left := vm.pop()
+	return vm.executeBinaryOperands(op, left, right)
+}
 
+// executeBinaryOperands 计算 left op right 并压入结果
+func (vm *VM) executeBinaryOperands(op code.Opcode, left, right object.Object) error {
 	leftType := left.Type()
 	rightType := right.Type()
 
 	switch {
 	case leftType == object.INTEGER_OBJ && rightType == object.INTEGER_OBJ:
 		return vm.executeBinaryIntegerOperation(op, left, right)
+	case isNumber(left) && isNumber(right):
+		return vm.executeBinaryFloatOperation(op, left, right)
 	case leftType == object.STRING_OBJ && rightType == object.STRING_OBJ:
 		return vm.executeBinaryStringOperation(op, left, right)
 	default:
@@ -329,7 +606,15 @@ func (vm *VM) executeBinaryIntegerOperation(
 	case code.OpMul:
 		result = leftValue * rightValue
 	case code.OpDiv:
+		if rightValue == 0 {
+			return fmt.Errorf("division by zero: %d / %d", leftValue, rightValue)
+		}
 		result = leftValue / rightValue
+	case code.OpMod:
+		if rightValue == 0 {
+			return fmt.Errorf("division by zero: %d %% %d", leftValue, rightValue)
+		}
+		result = leftValue % rightValue
 	default:
 		return fmt.Errorf("unknown integer operator: %d", op)
 	}
@@ -337,6 +622,34 @@ func (vm *VM) executeBinaryIntegerOperation(
 	return vm.push(&object.Integer{Value: result})
 }
 
+// executeBinaryFloatOperation 至少有一边是浮点数时,整数会被提升为浮点数
+func (vm *VM) executeBinaryFloatOperation(
+	op code.Opcode,
+	left, right object.Object,
+) error {
+	leftValue := toFloat(left)
+	rightValue := toFloat(right)
+
+	var result float64
+
+	switch op {
+	case code.OpAdd:
+		result = leftValue + rightValue
+	case code.OpSub:
+		result = leftValue - rightValue
+	case code.OpMul:
+		result = leftValue * rightValue
+	case code.OpDiv:
+		result = leftValue / rightValue
+	case code.OpMod:
+		result = math.Mod(leftValue, rightValue)
+	default:
+		return fmt.Errorf("unknown float operator: %d", op)
+	}
+
+	return vm.push(&object.Float{Value: result})
+}
+
 func (vm *VM) executeBinaryStringOperation(op code.Opcode, left, right object.Object) error {
 	if op != code.OpAdd {
 		return fmt.Errorf("unknown string operator %d", op)
@@ -345,38 +658,88 @@ func (vm *VM) executeBinaryStringOperation(op code.Opcode, left, right object.Ob
 	leftValue := left.(*object.String).Value
 	RightValue := right.(*object.String).Value
 
-	return vm.push(&object.String{Value: leftValue + RightValue})
+	result := &object.String{Value: leftValue + RightValue}
+	if err := vm.allocate(object.SizeOf(result)); err != nil {
+		return err
+	}
+	return vm.push(result)
 }
 
 func (vm *VM) executeComparsion(op code.Opcode) error {
 	right := vm.pop()
 	left := vm.pop()
+	result, err := compare(op, left, right)
+	if err != nil {
+		return err
+	}
+	return vm.push(nativeBoolToBooleanObject(result))
+}
+
+// compare 比较 left 和 right,比较并跳转的超级指令直接使用比较结果
+func compare(op code.Opcode, left, right object.Object) (bool, error) {
 	if left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ {
-		return vm.executeIntegerComparison(op, left, right)
+		return compareIntegers(op, left, right)
+	}
+	if isNumber(left) && isNumber(right) {
+		return compareFloats(op, left, right)
 	}
 	switch op {
 	case code.OpEqual:
-		return vm.push(nativeBoolToBooleanObject(right == left))
+		return right == left, nil
 	case code.OpNotEqual:
-		return vm.push(nativeBoolToBooleanObject(right != left))
+		return right != left, nil
 	default:
-		return fmt.Errorf("unknown operator: %d (%s %s)", op, left.Type(), right.Type())
+		return false, fmt.Errorf("unknown operator: %d (%s %s)", op, left.Type(), right.Type())
 	}
 }
 
-func (vm *VM) executeIntegerComparison(op code.Opcode, left, right object.Object) error {
+func compareIntegers(op code.Opcode, left, right object.Object) (bool, error) {
 	leftValue := left.(*object.Integer).Value
 	rightValue := right.(*object.Integer).Value
 
 	switch op {
 	case code.OpEqual:
-		return vm.push(nativeBoolToBooleanObject(rightValue == leftValue))
+		return rightValue == leftValue, nil
+	case code.OpNotEqual:
+		return rightValue != leftValue, nil
+	case code.OpGreaterThan:
+		return leftValue > rightValue, nil
+	case code.OpGreaterEqual:
+		return leftValue >= rightValue, nil
+	default:
+		return false, fmt.Errorf("unknown operator %d", op)
+	}
+}
+
+func compareFloats(op code.Opcode, left, right object.Object) (bool, error) {
+	leftValue := toFloat(left)
+	rightValue := toFloat(right)
+
+	switch op {
+	case code.OpEqual:
+		return rightValue == leftValue, nil
 	case code.OpNotEqual:
-		return vm.push(nativeBoolToBooleanObject(rightValue != leftValue))
+		return rightValue != leftValue, nil
 	case code.OpGreaterThan:
-		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
+		return leftValue > rightValue, nil
+	case code.OpGreaterEqual:
+		return leftValue >= rightValue, nil
+	default:
+		return false, fmt.Errorf("unknown operator %d", op)
+	}
+}
+
+// comparisonOf 比较并跳转的超级指令对应的比较指令
+func comparisonOf(op code.Opcode) code.Opcode {
+	switch op {
+	case code.OpJumpNotEqual:
+		return code.OpEqual
+	case code.OpJumpEqual:
+		return code.OpNotEqual
+	case code.OpJumpNotGreater:
+		return code.OpGreaterThan
 	default:
-		return fmt.Errorf("unknown operator %d", op)
+		return code.OpGreaterEqual
 	}
 }
 
@@ -397,12 +760,14 @@ func (vm *VM) executeBangOperator() error {
 
 func (vm *VM) excuteMinusOperator() error {
 	operand := vm.pop()
-	if operand.Type() != object.INTEGER_OBJ {
+	switch operand := operand.(type) {
+	case *object.Integer:
+		return vm.push(&object.Integer{Value: -operand.Value})
+	case *object.Float:
+		return vm.push(&object.Float{Value: -operand.Value})
+	default:
 		return fmt.Errorf("unsupported type for negation: %s", operand.Type())
 	}
-
-	value := operand.(*object.Integer).Value
-	return vm.push(&object.Integer{Value: -value})
 }
 
 func nativeBoolToBooleanObject(input bool) *object.Boolean {
@@ -412,6 +777,22 @@ func nativeBoolToBooleanObject(input bool) *object.Boolean {
 	return False
 }
 
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
+// toFloat 把整数或浮点数转换成float64
+func toFloat(obj object.Object) float64 {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value)
+	case *object.Float:
+		return obj.Value
+	default:
+		return 0
+	}
+}
+
 func isTruthy(obj object.Object) bool {
 	switch obj := obj.(type) {
 	case *object.Boolean:
@@ -434,6 +815,35 @@ func (vm *VM) executeIndexExpression(left, index object.Object) error {
 	}
 }
 
+// executeSetIndex 原地修改数组的元素或哈希表的键值对,再把值压回栈上
+func (vm *VM) executeSetIndex(left, index, value object.Object) error {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		arrayObject := left.(*object.Array)
+		i := index.(*object.Integer).Value
+		if i < 0 || i >= int64(len(arrayObject.Elements)) {
+			return fmt.Errorf("index out of range: %d (len %d)", i, len(arrayObject.Elements))
+		}
+		arrayObject.Elements[i] = value
+	case left.Type() == object.HASH_OBJ:
+		hashObject := left.(*object.Hash)
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+		hashKey := key.HashKey()
+		if _, ok := hashObject.Pairs[hashKey]; !ok {
+			if err := vm.allocate(object.HashPairSize); err != nil {
+				return err
+			}
+		}
+		hashObject.Pairs[hashKey] = object.HashPair{Key: index, Value: value}
+	default:
+		return fmt.Errorf("index assignment not supported: %s", left.Type())
+	}
+	return vm.push(value)
+}
+
 func (vm *VM) executeArrayIndex(array, index object.Object) error {
 	arrayObject := array.(*object.Array)
 	i := index.(*object.Integer).Value
@@ -464,9 +874,25 @@ func (vm *VM) currentFrame() *Frame {
 	return vm.frames[vm.framesIndex-1]
 }
 
-func (vm *VM) pushFrame(f *Frame) {
+func (vm *VM) pushFrame(f *Frame) error {
+	if vm.framesIndex >= len(vm.frames) {
+		return fmt.Errorf("stack overflow: call depth exceeds %d frames", len(vm.frames))
+	}
 	vm.frames[vm.framesIndex] = f
 	vm.framesIndex++
+	return nil
+}
+
+// reserveLocals 检查栈上放得下从 basePointer 开始的 numLocals 个局部变量
+func (vm *VM) reserveLocals(basePointer, numLocals int) error {
+	if basePointer+numLocals > len(vm.stack) {
+		return fmt.Errorf("stack overflow")
+	}
+	return nil
+}
+
+func (vm *VM) globalsExhausted(index int) error {
+	return fmt.Errorf("too many globals: index %d exceeds limit %d", index, len(vm.globals))
 }
 
 func (vm *VM) popFrame() *Frame {
@@ -480,8 +906,17 @@ func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
 	}
 
 	frame := NewFrame(cl, vm.sp-numArgs)
-	vm.pushFrame(frame)
+	if err := vm.reserveLocals(frame.basePointer, cl.Fn.NumLocals); err != nil {
+		return err
+	}
+	if err := vm.pushFrame(frame); err != nil {
+		return err
+	}
 	vm.sp = frame.basePointer + cl.Fn.NumLocals
+	// 清掉上一次调用留下的值,免得 OpSetLocal 写进别的闭包捕获的存储单元
+	for i := frame.basePointer + numArgs; i < vm.sp; i++ {
+		vm.stack[i] = nil
+	}
 	return nil
 }
 
@@ -497,9 +932,47 @@ func (vm *VM) executeCall(numArgs int) error {
 	}
 }
 
+// executeTailCall 被调用的是闭包时把它和参数挪到当前帧的位置上,复用当前帧,
+// 调用栈不会变深。其他情况和普通调用一样
+func (vm *VM) executeTailCall(numArgs int) error {
+	callee, ok := vm.stack[vm.sp-1-numArgs].(*object.Closure)
+	if !ok {
+		return vm.executeCall(numArgs)
+	}
+	if numArgs != callee.Fn.NumParameters {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d", callee.Fn.NumParameters, numArgs)
+	}
+
+	frame := vm.currentFrame()
+	if err := vm.reserveLocals(frame.basePointer, callee.Fn.NumLocals); err != nil {
+		return err
+	}
+	copy(vm.stack[frame.basePointer-1:], vm.stack[vm.sp-1-numArgs:vm.sp])
+	frame.cl = callee
+	frame.ip = -1
+	vm.sp = frame.basePointer + callee.Fn.NumLocals
+	for i := frame.basePointer + numArgs; i < vm.sp; i++ {
+		vm.stack[i] = nil
+	}
+	return nil
+}
+
+// callBuiltin ...
+// 内置函数返回的数组、字符串和哈希表都当作新分配的计入内存限制,是偏大的估计。
+// 内置函数已经通过 Allocate 登记过的部分不再重复计算
 func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
 	args := vm.stack[vm.sp-numArgs : vm.sp]
-	result := builtin.Fn(args...)
+	c := &caller{vm: vm}
+	result := builtin.Fn(c, args...)
+	if err := vm.callErr; err != nil {
+		vm.callErr = nil
+		return err
+	}
+	if size := object.SizeOf(result) - c.allocated; size > 0 {
+		if err := vm.allocate(size); err != nil {
+			return err
+		}
+	}
 	vm.sp = vm.sp - numArgs - 1
 	if result != nil {
 		vm.push(result)
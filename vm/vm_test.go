@@ -1,13 +1,18 @@
 package vm
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"monkey/ast"
 	"monkey/compiler"
+	"monkey/evaluator"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
+	"strings"
 	"testing"
+	"time"
 )
 
 func parse(input string) *ast.Program {
@@ -29,6 +34,32 @@ func testIntergerObject(expected int64, actual object.Object) error {
 	return nil
 }
 
+func testFloatObject(expected float64, actual object.Object) error {
+	result, ok := actual.(*object.Float)
+	if !ok {
+		return fmt.Errorf("object is not Float. got=%T (%+v)", actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%g, want=%g", result.Value, expected)
+	}
+
+	return nil
+}
+
+func testStringObject(expected string, actual object.Object) error {
+	result, ok := actual.(*object.String)
+	if !ok {
+		return fmt.Errorf("object is not String. got=%T (%+v)", actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%q, want=%q", result.Value, expected)
+	}
+
+	return nil
+}
+
 func testBooleanObject(expected bool, actual object.Object) error {
 	result, ok := actual.(*object.Boolean)
 	if !ok {
@@ -85,6 +116,16 @@ func testExpectedObject(
 		if err != nil {
 			t.Errorf("testIntegerObject failed:%s", err)
 		}
+	case float64:
+		err := testFloatObject(expected, actual)
+		if err != nil {
+			t.Errorf("testFloatObject failed:%s", err)
+		}
+	case string:
+		err := testStringObject(expected, actual)
+		if err != nil {
+			t.Errorf("testStringObject failed:%s", err)
+		}
 	case bool:
 		err := testBooleanObject(bool(expected), actual)
 		if err != nil {
@@ -106,6 +147,19 @@ func testExpectedObject(
 				t.Errorf("testIntegerObject failed: %s", err)
 			}
 		}
+	case []interface{}:
+		array, ok := actual.(*object.Array)
+		if !ok {
+			t.Errorf("object not Array: %T (%+v)", actual, actual)
+			return
+		}
+		if len(array.Elements) != len(expected) {
+			t.Errorf("wrong num of elements. want=%d,got=%d", len(expected), len(array.Elements))
+			return
+		}
+		for i, expectedElem := range expected {
+			testExpectedObject(t, expectedElem, array.Elements[i])
+		}
 	case map[object.HashKey]int64:
 		hash, ok := actual.(*object.Hash)
 		if !ok {
@@ -162,6 +216,58 @@ func TestIntegerArithmetic(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestModuloOperator(t *testing.T) {
+	tests := []vmTestCase{
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"6 % 3 + 1", 1},
+		{"7.5 % 2", 1.5},
+	}
+	runVmTests(t, tests)
+}
+
+func TestDivisionByZero(t *testing.T) {
+	tests := []vmTestCase{
+		{"1 / 0", "division by zero: 1 / 0"},
+		{"5 % 0", "division by zero: 5 % 0"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none.")
+		}
+
+		if err.Error() != tt.expected {
+			t.Fatalf("wrong VM error: want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestFloatArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"3.5", 3.5},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3.0},
+		{"1 + 0.5", 1.5},
+		{"0.5 * 4", 2.0},
+		{"7 / 2.0", 3.5},
+		{"1e3 - 1", 999.0},
+		{"(1.5 + 2) * -2", -7.0},
+		{`{1.5: "a"}[1.5]`, "a"},
+	}
+	runVmTests(t, tests)
+}
+
 func TestBooleanExpression(t *testing.T) {
 	tests := []vmTestCase{
 		{"true", true},
@@ -190,6 +296,26 @@ func TestBooleanExpression(t *testing.T) {
 		{"!!false", false},
 		{"!!5", true},
 		{"!(if (false){5;})", true},
+		{"1.5 < 2", true},
+		{"2 > 2.5", false},
+		{"1 == 1.0", true},
+		{"0.1 + 0.2 != 0.3", true},
+		{"1 <= 1", true},
+		{"2 <= 1", false},
+		{"1 >= 1", true},
+		{"1 >= 2", false},
+		{"1.5 >= 1", true},
+		{"1 <= 0.5", false},
+		{"true && true", true},
+		{"true && false", false},
+		{"1 && 2", true},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 < 2 && 2 < 3", true},
+		{"1 > 2 || 2 > 3", false},
+		{"let x = 0; (x != 0 && 10 / x > 1) || x == 0", true},
+		{"false && (1 / 0)", false},
+		{"true || (1 / 0)", true},
 	}
 	runVmTests(t, tests)
 }
@@ -273,6 +399,9 @@ func TestIndexExpressions(t *testing.T) {
 		{"{1: 1, 2: 2}[2]", 2},
 		{"{1: 1}[0]", Null},
 		{"{}[0]", Null},
+		{"{1: 1}[1.0]", 1},
+		{"{2.0: 2}[2]", 2},
+		{"{1.5: 1}[1]", Null},
 	}
 
 	runVmTests(t, tests)
@@ -741,3 +870,956 @@ func TestRecursiveFibonacci(t *testing.T) {
 
     runVmTests(t, tests)
 }
+
+func TestMacros(t *testing.T) {
+	tests := []string{
+		`
+        let unless = macro(cond, cons, alt) {
+            quote(if (!(unquote(cond))) { unquote(cons); } else { unquote(alt); });
+        };
+        unless(10 > 5, "not greater", "greater");
+        `,
+		`
+        let square = macro(x) { quote(unquote(x) * unquote(x)); };
+        let add = fn(a, b) { a + b };
+        add(square(3), len([square(2)]));
+        `,
+		`
+        let twice = macro(expr) { quote([unquote(expr), unquote(expr)]); };
+        let f = fn(x) { twice(x + 1) };
+        f(1);
+        `,
+	}
+
+	for _, input := range tests {
+		program := parse(input)
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		program = parse(input)
+		env := object.NewEnvironment()
+		macroEnv := object.NewEnvironment()
+		evaluator.DefineMacros(program, macroEnv)
+		expanded := evaluator.ExpandMacros(program, macroEnv)
+		evaluated := evaluator.Eval(expanded, env)
+
+		got := vm.LastPoppedStackElem().Inspect()
+		if got != evaluated.Inspect() {
+			t.Errorf("vm and evaluator disagree for %q. vm=%q, evaluator=%q",
+				input, got, evaluated.Inspect())
+		}
+	}
+}
+
+func TestLoops(t *testing.T) {
+	// 一万个元素的数组字面量会超出栈的大小,所以拆成 100 行
+	rows := make([]string, 100)
+	for i := range rows {
+		row := make([]string, 100)
+		for j := range row {
+			row[j] = fmt.Sprintf("%d", i*100+j)
+		}
+		rows[i] = "[" + strings.Join(row, ", ") + "]"
+	}
+	largeArray := "[" + strings.Join(rows, ", ") + "]"
+
+	tests := []vmTestCase{
+		{"while (false) { 1 }; 2", 2},
+		{"let f = fn() { while (true) { return 5; } }; f()", 5},
+		{"let f = fn() { while (true) { break; } 7 }; f()", 7},
+		{"let f = fn(arr) { for (x in arr) { if (x > 2) { return x; } } }; f([1, 2, 3, 4])", 3},
+		{"let f = fn(arr) { for (x in arr) { if (x < 3) { continue; } return x * 10; } }; f([1, 2, 3])", 30},
+		{"let f = fn() { for (x in [1, 2]) { break; } x }; f()", 1},
+		{"let f = fn(h) { for (k in h) { return h[k]; } }; f({3: 30, 1: 10, 2: 20})", 10},
+		{"let f = fn() { for (x in []) { return 1; } 0 }; f()", 0},
+		{"let f = fn() { for (x in [1]) { for (y in [2, 3]) { break; } return x + y; } }; f()", 3},
+		{"for (x in [1, 2, 3]) { if (x == 2) { continue; } x }; x", 3},
+		{"if (true) { let y = 1; }", Null},
+		{"let f = fn(rows) { for (row in rows) { for (x in row) { if (x == 9999) { return x; } } } }; f(" + largeArray + ")", 9999},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestLoopErrors(t *testing.T) {
+	program := parse("for (x in 5) { x }")
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+	if err == nil {
+		t.Fatalf("expected VM error but resulted in none.")
+	}
+	if err.Error() != "not iterable: INTEGER" {
+		t.Fatalf("wrong VM error: want=%q, got=%q", "not iterable: INTEGER", err)
+	}
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"let x = 1; x = 5; x", 5},
+		{"let x = 1; x = 5", 5},
+		{"let x = 1; x += 2; x", 3},
+		{"let x = 5; x -= 2; x", 3},
+		{"let x = 5; x *= 2; x", 10},
+		{"let x = 10; x /= 4; x", 2},
+		{"let x = 1.5; x *= 2; x", 3.0},
+		{`let s = "a"; s += "b"; s`, "ab"},
+		{"let x = 1; let y = 2; x = y = 7; x + y", 14},
+		{"let x = 1; let x = x + 1; x", 2},
+		{"let x = 1; let f = fn() { x = 10; }; f(); x", 10},
+		{"let f = fn(a) { a += 1; a }; f(1)", 2},
+		{"let sum = 0; for (x in [1, 2, 3, 4]) { sum += x; } sum", 10},
+		{"let i = 0; while (i < 5) { i += 1; } i", 5},
+		{"let f = fn() { let i = 0; let n = 0; while (i < 10) { i += 1; if (i % 2 == 0) { continue; } n += i; } n }; f()", 25},
+		{`
+		let makeCounter = fn() {
+			let count = 0;
+			fn() { count += 1; count }
+		};
+		let counter = makeCounter();
+		counter();
+		counter();
+		counter()
+		`, 3},
+		{`
+		let makeCounter = fn() {
+			let count = 0;
+			fn() { count += 1; count }
+		};
+		let a = makeCounter();
+		let b = makeCounter();
+		a();
+		a();
+		b()
+		`, 1},
+		{"let f = fn() { let c = 0; let inc = fn() { c += 1; }; inc(); inc(); c }; f()", 2},
+		{"let f = fn() { let c = 0; let get = fn() { c }; c = 5; get() }; f()", 5},
+		{"let f = fn() { let c = 0; let g = fn() { fn() { c += 1; } }; g()(); g()(); c }; f()", 2},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestAssignMatchesEvaluator(t *testing.T) {
+	tests := []string{
+		"let f = fn() { let c = 0; let inc = fn() { c += 1; }; inc(); c }; f()",
+		"let f = fn() { let fs = []; for (i in [1, 2, 3]) { let v = i; fs = push(fs, fn() { v }); } fs[0]() }; f()",
+		"let f = fn() { let fs = []; for (i in [1, 2, 3]) { let v = i; v = v * 10; fs = push(fs, fn() { v }); } fs[0]() }; f()",
+	}
+
+	for _, input := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		evaluated := evaluator.Eval(parse(input), object.NewEnvironment())
+		got := vm.LastPoppedStackElem().Inspect()
+		if got != evaluated.Inspect() {
+			t.Errorf("vm and evaluator disagree for %q. vm=%q, evaluator=%q",
+				input, got, evaluated.Inspect())
+		}
+	}
+}
+
+func TestLoopControlOutsideLoopMatchesEvaluator(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"break;", "break outside of a loop"},
+		{"1; continue; 2", "continue outside of a loop"},
+		{"let f = fn() { break; 5 }; f()", "break outside of a loop"},
+		{"let f = fn() { continue }; f()", "continue outside of a loop"},
+		{"while (true) { let f = fn() { break; }; f() }", "break outside of a loop"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err == nil || !strings.Contains(err.Error(), tt.expectedMessage) {
+			t.Errorf("wrong compiler error for %q. want=%q, got=%v", tt.input, tt.expectedMessage, err)
+		}
+
+		evaluated := evaluator.Eval(parse(tt.input), object.NewEnvironment())
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no evaluator error for %q. got=%T (%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != tt.expectedMessage {
+			t.Errorf("wrong evaluator error for %q. want=%q, got=%q", tt.input, tt.expectedMessage, errObj.Message)
+		}
+	}
+}
+
+func TestIndexAssignExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"let a = [1, 2, 3]; a[1] = 5; a[1]", 5},
+		{"let a = [1, 2, 3]; a[0] = 7", 7},
+		{"let a = [1, 2, 3]; a[2] += 10; a", []int{1, 2, 13}},
+		{"let a = [1, 2, 3]; let b = a; b[0] = 9; a[0]", 9},
+		{"let m = [[1, 2], [3, 4]]; m[1][0] *= 5; m[1][0]", 15},
+		{`let h = {}; h["k"] = 1; h["k"]`, 1},
+		{`let h = {"k": 1}; h["k"] -= 3; h`, map[object.HashKey]int64{(&object.String{Value: "k"}).HashKey(): -2}},
+		{`let h = {}; for (i in [1, 2, 3]) { h[i] = i * i; } h[3]`, 9},
+		{"let f = fn(a) { a[0] = 1; }; let a = [0]; f(a); a[0]", 1},
+		{"let i = 0; let f = fn() { i += 1; i }; let a = [0, 0, 0]; a[f()] += 5; a", []int{0, 5, 0}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestIndexAssignErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let a = [1]; a[1] = 2", "index out of range: 1 (len 1)"},
+		{"let h = {}; h[fn(x) { x }] = 1", "unusable as hash key: CLOSURE"},
+		{`let s = "abc"; s[0] = "x"`, "index assignment not supported: STRING"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error for %q but resulted in none.", tt.input)
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong VM error: want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestRuntimeErrorStackTrace(t *testing.T) {
+	// 尾调用会复用帧,这里的调用都不在尾位置,调用栈才是完整的
+	input := `let helper = fn(n) {
+  if (n == 0) {
+    return 1 + "a";
+  }
+  let r = helper(n - 1); r
+};
+let run = fn() { let r = helper(1); r };
+run();`
+
+	comp := compiler.New()
+	err := comp.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+	rerr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("expected *RuntimeError, got=%T (%v)", err, err)
+	}
+	if rerr.Message != "unsupported types for binary operation: INTEGER STRING" {
+		t.Errorf("wrong message. got=%q", rerr.Message)
+	}
+
+	expected := []struct {
+		function string
+		line     int
+	}{
+		{"helper", 3},
+		{"helper", 5},
+		{"run", 7},
+		{"<main>", 8},
+	}
+
+	if len(rerr.Frames) != len(expected) {
+		t.Fatalf("wrong number of frames. want=%d, got=%d\n%s",
+			len(expected), len(rerr.Frames), rerr.StackTrace())
+	}
+	for i, want := range expected {
+		frame := rerr.Frames[i]
+		if frame.Function != want.function || frame.Pos.Line != want.line {
+			t.Errorf("frames[%d] wrong. want=%s:%d, got=%s:%d",
+				i, want.function, want.line, frame.Function, frame.Pos.Line)
+		}
+	}
+}
+
+func TestSuperinstructionErrorPosition(t *testing.T) {
+	input := "let f = fn(x) {\n  if (x > 0) { 1 }\n  x - 1\n};\nf(\"a\");"
+
+	comp := compiler.New()
+	comp.SetOptimizationLevel(compiler.OptimizePeephole)
+	err := comp.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	err = New(comp.Bytecode()).Run()
+	rerr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("expected *RuntimeError, got=%T (%v)", err, err)
+	}
+	if rerr.Message != "unknown operator: 10 (STRING INTEGER)" {
+		t.Errorf("wrong message. got=%q", rerr.Message)
+	}
+	if pos := rerr.Frames[0].Pos; pos.Line != 2 || pos.Column != 7 {
+		t.Errorf("wrong position. want=2:7, got=%d:%d\n%s", pos.Line, pos.Column, rerr.StackTrace())
+	}
+}
+
+func TestOptimizedMatchesUnoptimized(t *testing.T) {
+	tests := []string{
+		"1 + 2 * 3 - 4 / 2 % 3",
+		"-(2 - 5) * 1.5 + 1",
+		`"mon" + "key"`,
+		"!(1 < 2) == (3 >= 4)",
+		"10 % 3 == 1 && 7 / 2 == 3",
+		"false || 0",
+		"true && !5",
+		"if (1 > 2) { 10 } else { 20 }",
+		"if (false) { 10 }",
+		"let x = 0; if (true) { x = 5; }; x",
+		"let i = 0; while (true) { i += 1; if (i == 5) { break } } i",
+		"let i = 0; while (false) { i = 100 } i",
+		"let f = fn(n) { 1; 2; n * (60 / 3) }; f(2)",
+		"let s = 0; for (x in [1 + 1, 2 * 2, 3 - 3]) { s += x; } s",
+		"if (false) { let y = 1 }; y",
+		"let f = fn(x) { if (x == 0) { 0 } else { x + 10 + f(x - 1) } }; f(5)",
+		"let f = fn(x) { if (x != 1) { 1 } else { 2 } }; [f(1), f(2), f(\"a\")]",
+		"let f = fn(a, b) { if (a > b) { a } else { b } }; [f(1, 2), f(2.5, 1), f(3, 3)]",
+		"let f = fn(a, b) { if (a >= b) { a } else { b } }; [f(1, 2), f(2.5, 1), f(3, 3)]",
+		"let f = fn(x) { let g = fn() { x = x + 1 }; g(); x - 1 }; f(10)",
+		"let f = fn(s) { s + \"!\" }; f(\"hi\")",
+		"let f = fn(x) { let i = 0; while (i < x) { i = i + 1; if (i == 3) { continue } } i }; f(5)",
+		"let f = fn(x) { x > true }; f(1)",
+	}
+
+	for _, input := range tests {
+		results := make([]string, 3)
+		for level := 0; level < 3; level++ {
+			comp := compiler.New()
+			comp.SetOptimizationLevel(level)
+			err := comp.Compile(parse(input))
+			if err != nil {
+				t.Fatalf("compiler error for %q at level %d: %s", input, level, err)
+			}
+			vm := New(comp.Bytecode())
+			err = vm.Run()
+			if err != nil {
+				results[level] = "error: " + err.Error()
+				continue
+			}
+			if elem := vm.LastPoppedStackElem(); elem != nil {
+				results[level] = elem.Inspect()
+			}
+		}
+		for level := 1; level < 3; level++ {
+			if results[0] != results[level] {
+				t.Errorf("optimization level %d changed the result of %q. unoptimized=%q, optimized=%q",
+					level, input, results[0], results[level])
+			}
+		}
+	}
+}
+
+func TestOptimizedRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 / 0", "division by zero: 1 / 0"},
+		{"5 % (2 - 2)", "division by zero: 5 % 0"},
+		{`"a" - "b"`, "unknown string operator 2"},
+		{"-true", "unsupported type for negation: BOOLEAN"},
+		{`let f = fn(x) { x - 1 }; f("a")`, "unsupported types for binary operation: STRING INTEGER"},
+		{"let f = fn(x) { if (x > 1) { 1 } }; f([])", "unknown operator: 10 (ARRAY INTEGER)"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		comp.SetOptimizationLevel(compiler.OptimizePeephole)
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Errorf("expected VM error for %q but resulted in none.", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong VM error for %q: want=%q, got=%q", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []vmTestCase{
+		{"let loop = fn(n, acc) { if (n == 0) { acc } else { loop(n - 1, acc + n) } }; loop(100000, 0)", 5000050000},
+		{`let fns = {};
+fns["even"] = fn(n) { if (n == 0) { return true; } fns["odd"](n - 1) };
+fns["odd"] = fn(n) { if (n == 0) { return false; } fns["even"](n - 1) };
+!fns["even"](10001) && fns["odd"](10001)`, true},
+		{`let build = fn(n, acc) { if (n == 0) { return acc; } build(n - 1, push(acc, n)) };
+let sum = fn(arr, i, acc) { if (i == len(arr)) { return acc; } sum(arr, i + 1, acc + arr[i]) };
+sum(build(5000, []), 0, 0)`, 12502500},
+		{"let f = fn(a) { len(a) }; f([1, 2, 3])", 3},
+		{"let f = fn(n) { if (n == 0) { fn() { n } } else { f(n - 1) } }; f(3)()", 0},
+		{"let f = fn(n) { let g = fn() { n }; if (n == 0) { g() } else { f(n - 1) } }; f(2000)", 0},
+		{"let count = fn(n) { let c = 0; let inc = fn() { c += 1 }; inc(); if (n == 0) { c } else { count(n - 1) } }; count(3000)", 1},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		comp.SetOptimizationLevel(compiler.OptimizeBasic)
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err != nil {
+			t.Fatalf("vm error for %q: %s", tt.input, err)
+		}
+		testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+	}
+}
+
+func TestTailCallErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let g = fn(a) { a }; let f = fn(a) { g(a, a) }; f(1)", "wrong number of arguments: want=1, got=2"},
+		{"let f = fn(a) { a(1) }; f(5)", "calling non-non-function and non-built-in"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		comp.SetOptimizationLevel(compiler.OptimizeBasic)
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		err = New(comp.Bytecode()).Run()
+		if err == nil {
+			t.Errorf("expected VM error for %q but resulted in none.", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong VM error for %q: want=%q, got=%q", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestResourceLimits(t *testing.T) {
+	tests := []struct {
+		input    string
+		opts     Options
+		expected string
+	}{
+		{"let f = fn(n) { 1 + f(n) }; f(1)", Options{}, "stack overflow"},
+		{"let f = fn(n) { 1 + f(n) }; f(1)", Options{StackSize: 1 << 16, MaxFrames: 50}, "stack overflow: call depth exceeds 50 frames"},
+		{"let f = fn() { let a = 1; let b = 2; let c = 3; a + b + c }; f()", Options{StackSize: 3}, "stack overflow"},
+		{"[1, 2, 3, 4, 5]", Options{StackSize: 4}, "stack overflow"},
+		{"let a = 1; let b = 2; let c = 3;", Options{GlobalsSize: 2}, "too many globals: index 2 exceeds limit 2"},
+		{"let a = 1; let b = 2; a + b", Options{Globals: make([]object.Object, 1)}, "too many globals: index 1 exceeds limit 1"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := NewWithOptions(comp.Bytecode(), tt.opts)
+		err = vm.Run()
+		if err == nil {
+			t.Errorf("expected VM error for %q with %+v but resulted in none.", tt.input, tt.opts)
+			continue
+		}
+		if _, ok := err.(*RuntimeError); !ok {
+			t.Errorf("expected *RuntimeError, got=%T (%v)", err, err)
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong VM error for %q: want=%q, got=%q", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestResourceLimitsAllowWithinLimits(t *testing.T) {
+	input := "let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(40)"
+
+	comp := compiler.New()
+	err := comp.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := NewWithOptions(comp.Bytecode(), Options{StackSize: 200, MaxFrames: 42, GlobalsSize: 1})
+	err = vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, 40, vm.LastPoppedStackElem())
+}
+
+func TestStackOverflowTrace(t *testing.T) {
+	comp := compiler.New()
+	err := comp.Compile(parse("let f = fn(n) { 1 + f(n) }; f(1)"))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	err = New(comp.Bytecode()).Run()
+	rerr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("expected *RuntimeError, got=%T (%v)", err, err)
+	}
+
+	lines := strings.Split(strings.TrimRight(rerr.StackTrace(), "\n"), "\n")
+	if len(lines) != maxTraceFrames+1 {
+		t.Fatalf("wrong number of trace lines. want=%d, got=%d\n%s", maxTraceFrames+1, len(lines), rerr.StackTrace())
+	}
+	omitted := fmt.Sprintf("    ... %d more frames", len(rerr.Frames)-maxTraceFrames)
+	if lines[maxTraceFrames/2] != omitted {
+		t.Errorf("wrong omitted line. want=%q, got=%q", omitted, lines[maxTraceFrames/2])
+	}
+	if !strings.HasPrefix(lines[len(lines)-1], "    at <main>") {
+		t.Errorf("last line is not the main frame. got=%q", lines[len(lines)-1])
+	}
+}
+
+func TestExecutionLimits(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		input    string
+		ctx      context.Context
+		maxSteps int
+		expected error
+	}{
+		{"while (true) { }", context.Background(), 1000, ErrExecutionLimit},
+		{"let f = fn(n) { f(n + 1) }; f(0)", context.Background(), 5000, ErrExecutionLimit},
+		{"let i = 0; while (i < 100000) { i += 1 }", context.Background(), 100, ErrExecutionLimit},
+		{"while (true) { }", cancelled, 0, ErrCancelled},
+		{"range(1099511627776)", context.Background(), 1000, ErrExecutionLimit},
+		{"map(range(1099511627776), fn(x) { x })", context.Background(), 1000, ErrExecutionLimit},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := NewWithOptions(comp.Bytecode(), Options{MaxSteps: tt.maxSteps})
+		err = vm.RunContext(tt.ctx)
+		if err == nil {
+			t.Errorf("expected VM error for %q but resulted in none.", tt.input)
+			continue
+		}
+		if !errors.Is(err, tt.expected) {
+			t.Errorf("wrong VM error for %q: want=%v, got=%v", tt.input, tt.expected, err)
+		}
+		if err.Error() != tt.expected.Error() {
+			t.Errorf("wrong VM error message for %q: want=%q, got=%q", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestExecutionLimitsTimeout(t *testing.T) {
+	tests := []string{
+		"while (true) { }",
+		"range(1099511627776)",
+	}
+
+	for _, input := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+
+		err = New(comp.Bytecode()).RunContext(ctx)
+		cancel()
+		if !errors.Is(err, ErrCancelled) {
+			t.Errorf("expected ErrCancelled for %q, got=%v", input, err)
+		}
+	}
+}
+
+func TestExecutionLimitsAllowWithinBudget(t *testing.T) {
+	input := "let i = 0; while (i < 100) { i += 1 }; i"
+
+	comp := compiler.New()
+	err := comp.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := NewWithOptions(comp.Bytecode(), Options{MaxSteps: 10000})
+	err = vm.RunContext(context.Background())
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, 100, vm.LastPoppedStackElem())
+}
+
+func TestMemoryLimits(t *testing.T) {
+	tests := []struct {
+		input    string
+		maxBytes int64
+	}{
+		{"let a = []; while (true) { a = push(a, 1) }", 1 << 20},
+		{`let s = "x"; while (true) { s = s + s }`, 1 << 20},
+		{`let s = "x"; while (true) { s += s }`, 1 << 20},
+		{"let h = {}; let i = 0; while (true) { h[i] = i; i += 1 }", 1 << 16},
+		{"let f = fn(a) { f(push(a, 1)) }; f([])", 1 << 20},
+		{"[1, 2, 3, 4, 5, 6, 7, 8]", 100},
+		{`{"a": 1, "b": 2}`, 100},
+		{"range(1099511627776)", 1 << 20},
+		{"range(9223372036854775807, -9223372036854775807, -1)", 1 << 20},
+		{"let a = range(1000); concat(a, a, a, a)", 1 << 15},
+		{"let a = range(1000); slice(a, 1)", 1 << 14},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := NewWithOptions(comp.Bytecode(), Options{MaxBytes: tt.maxBytes})
+		err = vm.Run()
+		if err == nil {
+			t.Errorf("expected VM error for %q but resulted in none.", tt.input)
+			continue
+		}
+		if !errors.Is(err, ErrMemoryLimit) {
+			t.Errorf("wrong VM error for %q: want=%v, got=%v", tt.input, ErrMemoryLimit, err)
+		}
+	}
+}
+
+func TestMemoryLimitsAllowWithinBudget(t *testing.T) {
+	input := `let a = []; let i = 0; while (i < 10) { a = push(a, "item"); i += 1 }; len(a)`
+
+	comp := compiler.New()
+	err := comp.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := NewWithOptions(comp.Bytecode(), Options{MaxBytes: 1 << 16})
+	err = vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, 10, vm.LastPoppedStackElem())
+}
+
+func TestCall(t *testing.T) {
+	input := `
+let offset = 10;
+let add = fn(a, b) { a + b + offset };
+let loop = fn(n) { if (n == 0) { "done" } else { loop(n - 1) } };
+let noValue = fn() { };
+`
+	comp := compiler.New()
+	err := comp.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	machine := New(comp.Bytecode())
+	if err := machine.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	global := func(index int) object.Object { return machine.globals[index] }
+
+	tests := []struct {
+		fn       object.Object
+		args     []object.Object
+		expected interface{}
+	}{
+		{global(1), []object.Object{&object.Integer{Value: 1}, &object.Integer{Value: 2}}, 13},
+		{global(2), []object.Object{&object.Integer{Value: 100}}, "done"},
+		{global(3), nil, Null},
+		{object.Builtins[0].Builtin, []object.Object{&object.String{Value: "four"}}, 4},
+	}
+	for _, tt := range tests {
+		result, err := machine.Call(tt.fn, tt.args...)
+		if err != nil {
+			t.Errorf("Call(%s) failed: %s", tt.fn.Inspect(), err)
+			continue
+		}
+		testExpectedObject(t, tt.expected, result)
+		if machine.sp != 0 || machine.framesIndex != 1 {
+			t.Errorf("vm state not restored. sp=%d, framesIndex=%d", machine.sp, machine.framesIndex)
+		}
+	}
+
+	_, err = machine.Call(global(1), &object.Integer{Value: 1}, True)
+	if err == nil || err.Error() != "unsupported types for binary operation: INTEGER BOOLEAN" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+	if machine.sp != 0 || machine.framesIndex != 1 {
+		t.Errorf("vm state not restored after error. sp=%d, framesIndex=%d", machine.sp, machine.framesIndex)
+	}
+}
+
+func TestCustomBuiltins(t *testing.T) {
+	registry := object.NewRegistry()
+	registry.Register("lookupUser", 1, func(caller object.Caller, args ...object.Object) object.Object {
+		return &object.String{Value: "user-" + args[0].Inspect()}
+	})
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"lookupUser(7)", "user-7"},
+		{"let f = fn(id) { lookupUser(id) }; f(8)", "user-8"},
+		{"len(lookupUser(1))", 6},
+		{"lookupUser()", &object.Error{Message: "wrong number of arguments. got=0, want=1"}},
+	}
+
+	for _, tt := range tests {
+		symbolTable := compiler.NewSymbolTable()
+		symbolTable.DefineBuiltins(registry)
+		comp := compiler.NewWithState(symbolTable, []object.Object{})
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := NewWithOptions(comp.Bytecode(), Options{Builtins: registry})
+		err = vm.Run()
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+	}
+}
+
+// newCallbackRegistry 登记一个用 caller 调用回调的内置函数 apply(f, x)
+func newCallbackRegistry() *object.Registry {
+	registry := object.NewRegistry()
+	registry.Register("apply", 2, func(caller object.Caller, args ...object.Object) object.Object {
+		return caller.Call(args[0], args[1])
+	})
+	return registry
+}
+
+func runWithCallbacks(t *testing.T, input string, opts Options) (*VM, error) {
+	opts.Builtins = newCallbackRegistry()
+	symbolTable := compiler.NewSymbolTable()
+	symbolTable.DefineBuiltins(opts.Builtins)
+	comp := compiler.NewWithState(symbolTable, []object.Object{})
+	err := comp.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := NewWithOptions(comp.Bytecode(), opts)
+	return vm, vm.Run()
+}
+
+func TestBuiltinCallbacks(t *testing.T) {
+	tests := []vmTestCase{
+		{"apply(fn(x) { x * 2 }, 21)", 42},
+		{"apply(len, \"four\")", 4},
+		{"let n = 10; apply(fn(x) { x + n }, 1) + 1", 12},
+		{"apply(fn(x) { apply(fn(y) { y + 1 }, x) * 3 }, 1)", 6},
+		{"let f = fn(x) { if (x == 0) { 0 } else { apply(f, x - 1) + 1 } }; f(20)", 20},
+		{"[1, apply(fn(x) { [x, x] }, 2), 3]", []interface{}{1, []interface{}{2, 2}, 3}},
+	}
+
+	for _, tt := range tests {
+		vm, err := runWithCallbacks(t, tt.input, Options{})
+		if err != nil {
+			t.Fatalf("vm error for %q: %s", tt.input, err)
+		}
+		testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+	}
+}
+
+func TestBuiltinCallbackErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		opts     Options
+		expected string
+		target   error
+	}{
+		{"apply(fn(x) { x + true }, 1)", Options{}, "unsupported types for binary operation: INTEGER BOOLEAN", nil},
+		{"apply(fn() { 1 }, 1)", Options{}, "wrong number of arguments: want=0, got=1", nil},
+		{"apply(fn(x) { while (true) { } }, 1)", Options{MaxSteps: 1000}, "execution limit exceeded", ErrExecutionLimit},
+		{"apply(fn(x) { apply(fn(y) { y + true }, x) }, 1); 5", Options{}, "unsupported types for binary operation: INTEGER BOOLEAN", nil},
+	}
+
+	for _, tt := range tests {
+		_, err := runWithCallbacks(t, tt.input, tt.opts)
+		if err == nil {
+			t.Errorf("expected VM error for %q but resulted in none.", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong VM error for %q: want=%q, got=%q", tt.input, tt.expected, err)
+		}
+		if tt.target != nil && !errors.Is(err, tt.target) {
+			t.Errorf("expected %q to wrap %v", err, tt.target)
+		}
+	}
+}
+
+func TestBuiltinCallbackStackTrace(t *testing.T) {
+	input := `let inner = fn(x) { x + true };
+let outer = fn(x) { apply(inner, x) };
+outer(1)`
+
+	_, err := runWithCallbacks(t, input, Options{})
+	rerr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("expected *RuntimeError, got=%T (%v)", err, err)
+	}
+	names := []string{}
+	for _, frame := range rerr.Frames {
+		names = append(names, frame.Function)
+	}
+	expected := []string{"inner", "outer", "<main>"}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Errorf("wrong frames. want=%v, got=%v", expected, names)
+	}
+}
+
+func TestCollectionBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{"map([1, 2, 3], fn(x) { x * 2 })", []int{2, 4, 6}},
+		{"map([], fn(x) { x })", []int{}},
+		{`map(["a", "bb"], len)`, []int{1, 2}},
+		{"map(1, fn(x) { x })", &object.Error{Message: "argument to `map` must be ARRAY, got INTEGER"}},
+		{"map([1])", &object.Error{Message: "wrong number of arguments. got=1, want=2"}},
+		{"filter([1, 2, 3, 4], fn(x) { x % 2 == 0 })", []int{2, 4}},
+		{"filter([1, 2], fn(x) { if (x > 1) { x } })", []int{2}},
+		{"reduce([1, 2, 3, 4], fn(acc, x) { acc + x }, 0)", 10},
+		{"reduce([], fn(acc, x) { acc + x }, 7)", 7},
+		{"reduce([1], fn(acc, x) { acc + x })", &object.Error{Message: "wrong number of arguments. got=2, want=3"}},
+		{"sort([3, 1, 2])", []int{1, 2, 3}},
+		{"sort([2.5, 1, 2])", []interface{}{1, 2, 2.5}},
+		{`sort(["b", "c", "a"])`, []interface{}{"a", "b", "c"}},
+		{"sort([3, 1, 2], fn(a, b) { a > b })", []int{3, 2, 1}},
+		{`sort([[2, "b"], [1, "a"], [2, "a"]], fn(a, b) { a[0] < b[0] })`,
+			[]interface{}{[]interface{}{1, "a"}, []interface{}{2, "b"}, []interface{}{2, "a"}}},
+		{`sort([1, "a"])`, &object.Error{Message: "argument to `sort` must be ARRAY of numbers or strings without a comparator"}},
+		{"let a = [2, 1]; sort(a); a", []int{2, 1}},
+		{"range(4)", []int{0, 1, 2, 3}},
+		{"range(2, 5)", []int{2, 3, 4}},
+		{"range(5, 0, -2)", []int{5, 3, 1}},
+		{"range(3, 1)", []int{}},
+		{"range(1, 5, 0)", &object.Error{Message: "`range` step must not be 0"}},
+		{`range("a")`, &object.Error{Message: "arguments to `range` must be INTEGER, got STRING"}},
+		{"range()", &object.Error{Message: "wrong number of arguments. got=0, want=1 to 3"}},
+		{"reverse([1, 2, 3])", []int{3, 2, 1}},
+		{"contains([1, 2, 3], 2)", true},
+		{"contains([1, 2, 3], 2.0)", true},
+		{`contains([1, "a"], "b")`, false},
+		{`contains("monkey", "key")`, true},
+		{`contains({"a": 1}, "a")`, true},
+		{`contains({"a": 1}, "b")`, false},
+		{"contains([1], 1) == true", true},
+		{"contains(1, 1)", &object.Error{Message: "argument to `contains` not supported, got INTEGER"}},
+		{"index_of([5, 6, 7], 7)", 2},
+		{"index_of([5, 6, 7], 8)", -1},
+		{`index_of("monkey", "key")`, 3},
+		{"slice([1, 2, 3, 4], 1)", []int{2, 3, 4}},
+		{"slice([1, 2, 3, 4], 1, 3)", []int{2, 3}},
+		{"slice([1, 2, 3, 4], -2)", []int{3, 4}},
+		{"slice([1, 2, 3, 4], 3, 1)", []int{}},
+		{"slice([1, 2], 0, 10)", []int{1, 2}},
+		{`slice("monkey", 3)`, "key"},
+		{`slice([1], "a")`, &object.Error{Message: "indices to `slice` must be INTEGER, got STRING"}},
+		{"concat([1], [], [2, 3])", []int{1, 2, 3}},
+		{"concat()", []int{}},
+		{"concat([1], 2)", &object.Error{Message: "arguments to `concat` must be ARRAY, got INTEGER"}},
+		{"reduce(map(filter(range(10), fn(x) { x % 3 == 0 }), fn(x) { x * x }), fn(a, b) { a + b }, 0)", 126},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestCollectionBuiltinErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{"map([1], fn(x) { x + true })", "unsupported types for binary operation: INTEGER BOOLEAN"},
+		{"map([1], fn() { 1 })", "wrong number of arguments: want=0, got=1"},
+		{"sort([1, 2, 3], fn(a, b) { a + true })", "unsupported types for binary operation: INTEGER BOOLEAN"},
+		{"reduce([1], fn(acc, x) { acc + x }, true)", "unsupported types for binary operation: BOOLEAN INTEGER"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		err = New(comp.Bytecode()).Run()
+		if err == nil {
+			t.Errorf("expected VM error for %q but resulted in none.", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong VM error for %q: want=%q, got=%q", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestCollectionBuiltinsMatchEvaluator(t *testing.T) {
+	tests := []string{
+		"map(range(5), fn(x) { x * x })",
+		"filter(range(10), fn(x) { x % 2 == 1 })",
+		"reduce(range(5), fn(acc, x) { acc + x }, 0)",
+		`sort(["pear", "apple", "fig"], fn(a, b) { len(a) < len(b) })`,
+		"let xs = [3, 1, 2]; [sort(xs), reverse(xs), contains(xs, 3), index_of(xs, 2), slice(xs, 1), concat(xs, xs)]",
+		"let count = 0; map([1, 2, 3], fn(x) { count += x; count }); count",
+	}
+
+	for _, input := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		evaluated := evaluator.Eval(parse(input), object.NewEnvironment())
+		got := vm.LastPoppedStackElem().Inspect()
+		if got != evaluated.Inspect() {
+			t.Errorf("vm and evaluator disagree for %q. vm=%q, evaluator=%q",
+				input, got, evaluated.Inspect())
+		}
+	}
+}